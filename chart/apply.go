@@ -0,0 +1,255 @@
+package chart
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// ErrPending is the error of a release whose last revision is pending, like
+// after a deploy which was killed, and which Apply did not recover
+var ErrPending = errors.New("another operation (install/upgrade/rollback) is in progress")
+
+// RecoverPolicy is how Apply recovers a release stuck in a pending state or
+// whose first install failed
+type RecoverPolicy int
+
+const (
+	// RecoverNone fails on a pending release and upgrades a failed first
+	// install, like helm
+	RecoverNone RecoverPolicy = iota
+	// RecoverRollback rolls a pending release back to its last deployed
+	// revision, a release never deployed is uninstalled and installed again
+	RecoverRollback
+	// RecoverReinstall uninstalls a pending release or a release never
+	// deployed and installs it again
+	RecoverReinstall
+)
+
+var recoverPolicies = []string{"none", "rollback", "reinstall"}
+
+func (p RecoverPolicy) String() string {
+	if p < 0 || int(p) >= len(recoverPolicies) {
+		return fmt.Sprintf("recover(%d)", int(p))
+	}
+	return recoverPolicies[p]
+}
+
+// ParseRecoverPolicy parses none, rollback or reinstall
+func ParseRecoverPolicy(s string) (RecoverPolicy, error) {
+	for i, name := range recoverPolicies {
+		if strings.EqualFold(s, name) {
+			return RecoverPolicy(i), nil
+		}
+	}
+	return RecoverNone, errors.Errorf("unknown recover policy %q, expected one of %s", s, strings.Join(recoverPolicies, ", "))
+}
+
+// ApplyAction is what Apply did to a release
+type ApplyAction string
+
+const (
+	// ApplyInstalled is a release installed
+	ApplyInstalled ApplyAction = "installed"
+	// ApplyUpgraded is a release upgraded
+	ApplyUpgraded ApplyAction = "upgraded"
+	// ApplyRolledBack is a pending release rolled back before it is upgraded
+	ApplyRolledBack ApplyAction = "rolled back"
+	// ApplyUninstalled is a pending or failed release uninstalled before it
+	// is installed again
+	ApplyUninstalled ApplyAction = "uninstalled"
+)
+
+// ApplyOptions are the options of Helm.Apply, the UpgradeOptions are the
+// ones of the install too
+type ApplyOptions struct {
+	UpgradeOptions
+	// CreateNamespace creates the namespace of the release when it is installed
+	CreateNamespace bool
+	// Recover is how a pending or failed release is recovered, no release
+	// is recovered in a dry run
+	Recover RecoverPolicy
+}
+
+// ApplyResult is the release Apply deployed and how
+type ApplyResult struct {
+	Release *release.Release
+	// Action is ApplyInstalled or ApplyUpgraded
+	Action ApplyAction
+	// Recovered is ApplyRolledBack or ApplyUninstalled when the release was
+	// recovered first, empty otherwise
+	Recovered ApplyAction
+}
+
+func (r *ApplyResult) String() string {
+	if r.Recovered != "" {
+		return fmt.Sprintf("release %q %s, then %s\n", r.Release.Name, r.Recovered, r.Action)
+	}
+	return fmt.Sprintf("release %q %s\n", r.Release.Name, r.Action)
+}
+
+// Apply installs the chart as the release when it is absent and upgrades it
+// when it is present, like helm upgrade --install. A release whose history
+// was kept when it was uninstalled is installed again. A release stuck in a
+// pending state or whose first install failed is recovered by the policy of
+// the options first.
+func (h *Helm) Apply(namespace, chartName, releaseName string, opts ApplyOptions) (*ApplyResult, error) {
+	return h.apply(namespace, releaseName, opts,
+		func(o InstallOptions) (*release.Release, error) {
+			return h.Install(namespace, chartName, releaseName, o)
+		},
+		func(o UpgradeOptions) (*release.Release, error) {
+			return h.Upgrade(namespace, chartName, releaseName, o)
+		})
+}
+
+// ApplyChart installs or upgrades the release with a chart in memory, like
+// the chart of BuildChart, see Apply
+func (h *Helm) ApplyChart(namespace string, c *chart.Chart, releaseName string, opts ApplyOptions) (*ApplyResult, error) {
+	return h.apply(namespace, releaseName, opts,
+		func(o InstallOptions) (*release.Release, error) {
+			return h.InstallChart(namespace, c, releaseName, o)
+		},
+		func(o UpgradeOptions) (*release.Release, error) {
+			return h.UpgradeChart(namespace, c, releaseName, o)
+		})
+}
+
+// ApplyApps installs or upgrades the release with the chart of the apps,
+// nothing is written to disk, see Apply
+func (h *Helm) ApplyApps(namespace string, apps *Apps, releaseName string, opts ApplyOptions) (*ApplyResult, error) {
+	c, err := BuildChart(apps)
+	if err != nil {
+		return nil, err
+	}
+	return h.ApplyChart(namespace, c, releaseName, opts)
+}
+
+// apply recovers the release, then installs or upgrades it
+func (h *Helm) apply(namespace, releaseName string, opts ApplyOptions,
+	install func(InstallOptions) (*release.Release, error), upgrade func(UpgradeOptions) (*release.Release, error)) (*ApplyResult, error) {
+	config, err := h.actionConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+	last, err := lastRelease(config, releaseName)
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplyResult{}
+	if last != nil && !opts.DryRun {
+		if result.Recovered, err = h.recoverRelease(config, namespace, last, opts); err != nil {
+			return nil, err
+		}
+		if result.Recovered != "" {
+			if last, err = lastRelease(config, releaseName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if last != nil && last.Info.Status.IsPending() {
+		return nil, errors.Wrapf(ErrPending, "release %s is %s", releaseName, last.Info.Status)
+	}
+
+	if last == nil || last.Info.Status == release.StatusUninstalled {
+		result.Action = ApplyInstalled
+		o := opts.installOptions()
+		o.Replace = last != nil
+		result.Release, err = install(o)
+	} else {
+		result.Action = ApplyUpgraded
+		result.Release, err = upgrade(opts.UpgradeOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lastRelease returns the last revision of the release, nil when there is none
+func lastRelease(config *action.Configuration, name string) (*release.Release, error) {
+	last, err := config.Releases.Last(name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, nil
+	}
+	return last, err
+}
+
+// recoverRelease recovers a pending release or a release never deployed by
+// the policy, it returns what was done
+func (h *Helm) recoverRelease(config *action.Configuration, namespace string, last *release.Release, opts ApplyOptions) (ApplyAction, error) {
+	if opts.Recover == RecoverNone {
+		return "", nil
+	}
+	deployed, err := config.Releases.Deployed(last.Name)
+	if err != nil && !errors.Is(err, driver.ErrNoDeployedReleases) {
+		return "", err
+	}
+	pending := last.Info.Status.IsPending()
+	// a release which failed before it was ever deployed, a failed upgrade
+	// is upgraded again
+	neverDeployed := deployed == nil && last.Info.Status == release.StatusFailed && !everDeployed(config, last.Name)
+	switch {
+	case !pending && !neverDeployed:
+		return "", nil
+	case opts.Recover == RecoverRollback && pending && deployed != nil:
+		h.logger("release %s is %s, rolling back to revision %d\n", last.Name, last.Info.Status, deployed.Version)
+		err := h.Rollback(namespace, last.Name, RollbackOptions{
+			Revision:    deployed.Version,
+			Timeout:     opts.Timeout,
+			Wait:        opts.Wait,
+			WaitForJobs: opts.WaitForJobs,
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "rolling back the %s release %s", last.Info.Status, last.Name)
+		}
+		return ApplyRolledBack, nil
+	}
+	h.logger("release %s is %s, uninstalling it\n", last.Name, last.Info.Status)
+	uninstall := action.NewUninstall(config)
+	uninstall.Timeout = timeout(opts.Timeout)
+	uninstall.Wait = opts.Wait
+	if _, err := uninstall.Run(last.Name); err != nil {
+		return "", errors.Wrapf(err, "uninstalling the %s release %s", last.Info.Status, last.Name)
+	}
+	return ApplyUninstalled, nil
+}
+
+// everDeployed tells whether a revision of the release was deployed once, the
+// deployed revisions are superseded by the next ones
+func everDeployed(config *action.Configuration, name string) bool {
+	history, err := config.Releases.History(name)
+	if err != nil {
+		return false
+	}
+	for _, rel := range history {
+		if rel.Info.Status == release.StatusDeployed || rel.Info.Status == release.StatusSuperseded {
+			return true
+		}
+	}
+	return false
+}
+
+// installOptions are the options of installing the release of Apply
+func (o *ApplyOptions) installOptions() InstallOptions {
+	return InstallOptions{
+		ValueOptions:    o.ValueOptions,
+		CreateNamespace: o.CreateNamespace,
+		Version:         o.Version,
+		Timeout:         o.Timeout,
+		Wait:            o.Wait,
+		WaitForJobs:     o.WaitForJobs,
+		Atomic:          o.Atomic,
+		DryRun:          o.DryRun,
+		SkipCRDs:        o.SkipCRDs,
+		DisableHooks:    o.DisableHooks,
+		Description:     o.Description,
+		Labels:          o.Labels,
+		Dependencies:    o.Dependencies,
+	}
+}
@@ -0,0 +1,454 @@
+package chart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// BuildChart assembles the chart of the apps in memory: Chart.yaml, values.yaml,
+// the values files of the environments, _helpers.tpl, NOTES.txt and the templates
+// of every app. Nothing is written to disk, the chart can be rendered, linted,
+// saved with SaveChart or chartutil.SaveDir, or packaged with PackageChart.
+// The problems of all the apps are returned together as GenerateErrors.
+func BuildChart(apps *Apps) (*chart.Chart, error) {
+	c, _, err := buildChart(apps)
+	return c, err
+}
+
+func buildChart(apps *Apps) (*chart.Chart, map[string]origin, error) {
+	files, origins, err := buildFiles(apps)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "loading chart %s", apps.Name)
+	}
+	return c, origins, nil
+}
+
+// origin is the app and the template kind a file of the chart is generated for
+type origin struct {
+	app  string
+	kind string
+}
+
+// buildFiles returns the files of the chart of the apps, named relative to the
+// chart directory, and the origin of each file
+func buildFiles(apps *Apps) ([]*loader.BufferedFile, map[string]origin, error) {
+	if err := validateChartName(apps.Name); err != nil {
+		return nil, nil, &GenerateError{Path: ChartfileName, Err: err}
+	}
+	chartfile, err := chartfileContent(apps)
+	if err != nil {
+		return nil, nil, &GenerateError{Path: ChartfileName, Err: err}
+	}
+	var errs GenerateErrors
+	origins := make(map[string]origin)
+	files := []*loader.BufferedFile{
+		{Name: ChartfileName, Data: chartfile},
+	}
+	for _, app := range apps.Sets {
+		if _, err := yaml.Marshal(app.Values); err != nil {
+			errs = append(errs, &GenerateError{App: app.Name, Path: ValuesfileName, Err: err})
+		}
+	}
+	if values, err := valuesContent(apps, apps.BaseValues); err == nil {
+		files = append(files, &loader.BufferedFile{Name: ValuesfileName, Data: values})
+	} else if len(errs) == 0 {
+		errs = append(errs, &GenerateError{Path: ValuesfileName, Err: err})
+	}
+	if schema, err := valuesSchemaContent(apps); err == nil {
+		files = append(files, &loader.BufferedFile{Name: SchemafileName, Data: schema})
+	} else {
+		errs = errs.add(err, "", SchemafileName)
+	}
+	envs, err := envValuesContents(apps)
+	if err != nil {
+		errs = errs.add(err, "", "")
+	}
+	files = append(files, envs...)
+
+	helpers := []string{defaultHelpers}
+	for _, app := range apps.Sets {
+		helpers = append(helpers, appHelpers(app))
+	}
+	helpers = append(helpers, defaultUserHelpers)
+	files = append(files,
+		&loader.BufferedFile{Name: TemplatesDir + "/" + strings.TrimPrefix(HelpersName, string(filepath.Separator)), Data: transform(strings.Join(helpers, ""), apps.Name)},
+		&loader.BufferedFile{Name: TemplatesDir + "/" + NotesFileName, Data: notesContent(apps)},
+	)
+
+	for _, app := range apps.Sets {
+		templates, err := appTemplateFiles(apps, app)
+		if err != nil {
+			errs = errs.add(err, app.Name, TemplatesDir)
+		}
+		for _, f := range templates {
+			name := TemplatesDir + "/" + f.Name
+			if prev, ok := origins[name]; ok {
+				errs = append(errs, &GenerateError{App: app.Name, Kind: f.kind, Path: name,
+					Err: errors.Errorf("also generated for app %s", prev.app)})
+				continue
+			}
+			origins[name] = origin{app: app.Name, kind: f.kind}
+			files = append(files, &loader.BufferedFile{Name: name, Data: f.Data})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return files, origins, nil
+}
+
+// appHelpers is the section of an app in _helpers.tpl, between the lines
+// helm-maker:app-begin <APPNAME> and helm-maker:app-end <APPNAME>
+func appHelpers(app *App) string {
+	return strings.NewReplacer(
+		APPNAME, app.Name,
+		appConfigMap, fmt.Sprint(app.HasType("configmap")),
+		appSecret, fmt.Sprint(app.HasType("secret")),
+	).Replace(appHelpersBegin + defaultAppHelpers + appHelpersEnd)
+}
+
+const (
+	appHelpersBegin = "{{/* helm-maker:app-begin <APPNAME> */}}"
+	appHelpersEnd   = "{{/* helm-maker:app-end <APPNAME> */}}\n"
+	// appConfigMap and appSecret are true in the helpers of an app of the
+	// configmap and of the secret kinds, false otherwise
+	appConfigMap = "<APPCONFIGMAP>"
+	appSecret    = "<APPSECRET>"
+)
+
+// defaultAppVersion is the appVersion of defaultChartfile, the templates use it
+// as the image tag and the version label of the apps which set none
+const defaultAppVersion = "1.16.0"
+
+// chartMetadata is the metadata of the chart of the apps, an application
+// chart of version 0.1.0 unless the spec tells otherwise
+func chartMetadata(apps *Apps) *chart.Metadata {
+	md := &chart.Metadata{
+		APIVersion:  chart.APIVersionV2,
+		Name:        apps.Name,
+		Version:     apps.Version,
+		AppVersion:  defaultAppVersion,
+		Description: "A Helm chart for Kubernetes",
+		Type:        "application",
+	}
+	if md.Version == "" {
+		md.Version = "0.1.0"
+	}
+	m := apps.Metadata
+	if m == nil {
+		return md
+	}
+	if m.Description != "" {
+		md.Description = m.Description
+	}
+	if m.Type != "" {
+		md.Type = m.Type
+	}
+	if m.AppVersion != "" {
+		md.AppVersion = m.AppVersion
+	}
+	md.KubeVersion = m.KubeVersion
+	md.Keywords = m.Keywords
+	md.Home = m.Home
+	md.Sources = m.Sources
+	md.Maintainers = m.Maintainers
+	md.Icon = m.Icon
+	md.Annotations = m.Annotations
+	return md
+}
+
+// chartfileContent is the Chart.yaml of the apps, its metadata is validated
+// like helm does before it is written in the order of defaultChartfile
+func chartfileContent(apps *Apps) ([]byte, error) {
+	md := chartMetadata(apps)
+	if err := md.Validate(); err != nil {
+		return nil, err
+	}
+	if md.KubeVersion != "" {
+		if _, err := semver.NewConstraint(md.KubeVersion); err != nil {
+			return nil, chart.ValidationErrorf("chart.metadata.kubeVersion %q is invalid: %s", md.KubeVersion, err)
+		}
+	}
+	return orderedYAML(md, chartfileDoc())
+}
+
+// envValuesContents are the values-<env>.yaml files of the apps, sorted by env
+func envValuesContents(apps *Apps) ([]*loader.BufferedFile, error) {
+	envs := make([]string, 0, len(apps.Envs))
+	for env := range apps.Envs {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	files := make([]*loader.BufferedFile, 0, len(envs))
+	for _, env := range envs {
+		name := fmt.Sprintf(EnvValuesfileName, env)
+		value, err := yaml.Marshal(apps.Envs[env])
+		if err != nil {
+			return nil, &GenerateError{Path: name, Err: err}
+		}
+		files = append(files, &loader.BufferedFile{Name: name, Data: value})
+	}
+	return files, nil
+}
+
+// notesContent is the NOTES.txt listing how to reach every app
+func notesContent(apps *Apps) []byte {
+	var b strings.Builder
+	b.WriteString(defaultNotes)
+	for _, app := range apps.Sets {
+		var notes strings.Builder
+		notes.WriteString(defaultAppNotes)
+		access := defaultAppNotesPods
+		if app.HasType("svc", "service") {
+			access = defaultAppNotesService
+		}
+		if app.HasType("ingress") {
+			notes.WriteString("{{- if (.Values.<APPNAME>.value.ingress | default dict).enabled }}\n")
+			notes.WriteString(defaultAppNotesIngress)
+			notes.WriteString("{{- else }}\n")
+			notes.WriteString(access)
+			notes.WriteString("{{- end }}\n")
+		} else {
+			notes.WriteString(access)
+		}
+		b.WriteString(strings.ReplaceAll(notes.String(), APPNAME, app.Name))
+	}
+	return transform(b.String(), apps.Name)
+}
+
+// templateFile is a template of an app and its template kind, empty for the
+// templates of App.Templates
+type templateFile struct {
+	chart.File
+	kind string
+}
+
+// appTemplateFiles are the templates of an app named relative to the templates
+// directory: the template kinds of its types and its own templates, sorted by
+// name. The problems of the app are returned as GenerateErrors.
+func appTemplateFiles(apps *Apps, app *App) ([]*templateFile, error) {
+	if !appName.MatchString(app.Name) {
+		return nil, GenerateErrors{{App: app.Name,
+			Err: errors.Errorf("app name must match the regular expression %q", appName.String())}}
+	}
+	var errs GenerateErrors
+	var files []*templateFile
+	for _, t := range app.Types {
+		m, ok := model[t]
+		if !ok {
+			errs = append(errs, &GenerateError{App: app.Name, Kind: t,
+				Err: errors.Errorf("unknown template kind %q, expected one of %s", t, strings.Join(model.Kinds(), ", "))})
+			continue
+		}
+		content := strings.ReplaceAll(m.Content, APPNAME, app.Name)
+		content = strings.ReplaceAll(content, CHARTNAME, apps.Name)
+		files = append(files, &templateFile{File: chart.File{Name: fmt.Sprintf(m.FileName, app.Name), Data: []byte(content)}, kind: t})
+	}
+	names := make([]string, 0, len(app.Templates))
+	for name := range app.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files = append(files, &templateFile{File: chart.File{Name: name, Data: []byte(app.Templates[name])}})
+	}
+	return files, errs.err()
+}
+
+// ChartFiles returns the files of a chart as chartutil.SaveDir writes them,
+// named relative to the chart directory and sorted by name. Dependencies are
+// not included.
+func ChartFiles(c *chart.Chart) ([]*chart.File, error) {
+	if c.Metadata == nil {
+		return nil, errors.New("chart has no metadata")
+	}
+	meta, err := orderedYAML(c.Metadata, chartfileDoc())
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal Chart.yaml")
+	}
+	files := []*chart.File{{Name: ChartfileName, Data: meta}}
+	for _, f := range c.Raw {
+		if f.Name == ValuesfileName {
+			files = append(files, f)
+		}
+	}
+	if c.Schema != nil {
+		files = append(files, &chart.File{Name: SchemafileName, Data: c.Schema})
+	}
+	files = append(files, c.Templates...)
+	files = append(files, c.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// SaveChart writes the chart into the directory dir/<chart name>. The chart is
+// written to a temporary directory next to it first, which then replaces the
+// chart directory, so a failure never leaves a half written chart behind. The
+// files of an existing chart directory which are not part of the chart, like
+// templates added by hand, are kept. Diff shows what saving would change.
+//
+// Files generated before carry a header with the checksum of their content.
+// A file edited by hand since is kept, and reported as a conflict when its
+// generated content changed too, see Generate.
+func SaveChart(c *chart.Chart, dir string, opts ...GenerateOpt) (string, error) {
+	r, err := saveChart(c, dir, nil, opts)
+	if r == nil {
+		return filepath.Join(dir, c.Name()), err
+	}
+	return r.Dir, err
+}
+
+// Generate builds the chart of the apps and saves it into apps.Path like
+// SaveChart. The report lists every file of the chart and whether it was
+// created, overwritten, skipped or kept because of a conflict.
+//
+// Regenerating a chart is idempotent. Every generated file starts with a
+// header holding the checksum of its generated content, which tells whether
+// the file was edited by hand since:
+//   - a file which was not edited is regenerated
+//   - an edited file is kept while its generated content stays the same
+//   - an edited file whose generated content changed is a conflict, the file
+//     is kept and an ErrConflict is returned for it after the chart is saved
+//   - a file without a header is kept, it was not generated by helm-maker
+//   - a generated file which is no longer part of the chart, like the template
+//     of a kind removed from an app, is removed unless it was edited
+//
+// The lines between helm-maker:begin <name> and helm-maker:end <name> are user
+// regions, like the one at the end of _helpers.tpl. They are not part of the
+// checksum and are kept when the file is regenerated. WithForce overwrites all
+// the files instead.
+func Generate(apps *Apps, opts ...GenerateOpt) (*Report, error) {
+	path, err := filepath.Abs(apps.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, errors.Errorf("no such directory %s", path)
+	}
+	c, origins, err := buildChart(apps)
+	if err != nil {
+		return nil, err
+	}
+	return saveChart(c, path, origins, opts)
+}
+
+func saveChart(c *chart.Chart, dir string, origins map[string]origin, opts []GenerateOpt) (*Report, error) {
+	plan, err := planChart(c, dir, origins, opts)
+	if err != nil {
+		if plan == nil {
+			return nil, err
+		}
+		return plan.report, err
+	}
+	report, cdir, exists := plan.report, plan.dir, plan.exists
+	owned := make(map[string]bool, len(plan.files))
+	for _, p := range plan.files {
+		if p.data != nil || p.report.Action == FileRemoved {
+			owned[filepath.FromSlash(p.name)] = true
+		}
+	}
+
+	tmp, err := ioutil.TempDir(dir, "."+c.Name()+"-")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(tmp)
+	if exists {
+		if err := copyDir(cdir, tmp, owned); err != nil {
+			return report, errors.Wrapf(err, "copying %s", cdir)
+		}
+	}
+	var errs GenerateErrors
+	for _, p := range plan.files {
+		if p.data == nil {
+			continue
+		}
+		if err := writeFile(filepath.Join(tmp, filepath.FromSlash(p.name)), p.data); err != nil {
+			errs = append(errs, &GenerateError{App: p.report.App, Kind: p.report.Kind, Path: p.name, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return report, errs
+	}
+	for _, dep := range c.Dependencies() {
+		if _, err := chartutil.Save(dep, filepath.Join(tmp, ChartsDir)); err != nil {
+			return report, errors.Wrapf(err, "saving %s", dep.ChartFullPath())
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return report, err
+	}
+	if plan.opts.check != nil {
+		if report.Check, err = plan.opts.check(tmp); err != nil {
+			return report, err
+		}
+	}
+
+	if !exists {
+		if err := os.Rename(tmp, cdir); err != nil {
+			return report, err
+		}
+		return report, plan.conflicts.err()
+	}
+	old := tmp + ".old"
+	if err := os.Rename(cdir, old); err != nil {
+		return report, err
+	}
+	if err := os.Rename(tmp, cdir); err != nil {
+		if rerr := os.Rename(old, cdir); rerr != nil {
+			return report, errors.Wrapf(err, "the previous chart is kept at %s", old)
+		}
+		return report, err
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return report, err
+	}
+	return report, plan.conflicts.err()
+}
+
+// copyDir copies the files of src into dst, except the files named in skip
+func copyDir(src, dst string, skip map[string]bool) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), fi.Mode().Perm()|0700)
+		}
+		if skip[rel] || !fi.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), data, fi.Mode().Perm())
+	})
+}
+
+// PackageChart saves the chart as the archive <name>-<version>.tgz in dir and
+// returns its path
+func PackageChart(c *chart.Chart, dir string) (string, error) {
+	return chartutil.Save(c, dir)
+}
@@ -0,0 +1,444 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// Bump is the part of the chart version a change increments
+type Bump int
+
+const (
+	// BumpNone keeps the version, the chart did not change
+	BumpNone Bump = iota
+	// BumpPatch is a change of values, templates or metadata
+	BumpPatch
+	// BumpMinor is an added app or template
+	BumpMinor
+	// BumpMajor is a removed app or template, or a removed or renamed value
+	BumpMajor
+)
+
+var bumps = []string{"none", "patch", "minor", "major"}
+
+func (b Bump) String() string {
+	if b < 0 || int(b) >= len(bumps) {
+		return fmt.Sprintf("bump(%d)", int(b))
+	}
+	return bumps[b]
+}
+
+// MarshalText writes the bump as its name
+func (b Bump) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// notPrerelease matches the characters which can't be used in a prerelease
+var notPrerelease = regexp.MustCompile("[^0-9A-Za-z-]+")
+
+// Prerelease is the prerelease of a version built from a git commit, like the
+// COMMIT_ID of a pipeline: 32cbee7e294 is g32cbee7e294. The prefix keeps an
+// all digits commit a valid prerelease.
+func Prerelease(commit string) string {
+	commit = strings.Trim(notPrerelease.ReplaceAllString(commit, "-"), "-")
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if commit == "" {
+		return ""
+	}
+	return "g" + commit
+}
+
+// VersionChange is how the version of a chart was bumped
+type VersionChange struct {
+	// Previous is the version of the previous chart, empty when there is none
+	Previous string `json:"previous,omitempty"`
+	Version  string `json:"version"`
+	Bump     Bump   `json:"bump"`
+	// Reasons are the changes which bumped the version, like "app api added"
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func (v *VersionChange) String() string {
+	if v.Previous == "" {
+		return fmt.Sprintf("version %s\n", v.Version)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "version %s -> %s (%s)\n", v.Previous, v.Version, v.Bump)
+	for _, r := range v.Reasons {
+		b.WriteString("  " + r + "\n")
+	}
+	return b.String()
+}
+
+// WithBump bumps the version of the chart from the version of the previous
+// chart by how it changed, see BumpVersion. The previous chart is the chart
+// directory being regenerated, unless WithPrevious gives another one.
+// prerelease is added to a bumped version, see Prerelease.
+func WithBump(prerelease string) GenerateOpt {
+	return func(o *generateOptions) {
+		o.bump = true
+		o.prerelease = prerelease
+	}
+}
+
+// WithPrevious compares the chart with c to bump its version, like the
+// latest chart of a repository returned by Helm.LatestChart
+func WithPrevious(c *chart.Chart) GenerateOpt {
+	return func(o *generateOptions) {
+		o.previous = c
+	}
+}
+
+// bumpChart bumps the version of the chart saved into the chart directory
+// cdir, the previous chart is the one of the options or the one of cdir
+func bumpChart(c *chart.Chart, cdir string, o *generateOptions) (*VersionChange, error) {
+	prev := o.previous
+	if prev == nil {
+		if _, err := os.Stat(filepath.Join(cdir, ChartfileName)); err == nil {
+			if prev, err = loader.Load(cdir); err != nil {
+				return nil, errors.Wrapf(err, "loading the previous chart %s", cdir)
+			}
+		}
+	}
+	return bumpVersion(c, prev, o.prerelease, o.previous == nil)
+}
+
+// BumpVersion sets the version of the chart c from the version of the
+// previous chart prev and how c changed from it, see CompareCharts. The
+// version of c is kept when it is higher, like a version set in the spec, and
+// when there is no previous chart. A bumped version gets the prerelease after
+// a build number, so a patch of 1.2.3 with the prerelease g32cbee7 is
+// 1.2.4-1.g32cbee7, and the next patch of 1.2.4-1.g32cbee7 is 1.2.4-2.g4a1b2c3
+// with the prerelease g4a1b2c3, or 1.2.4 without one. The commits are not
+// ordered, the build number keeps every version higher than the previous one.
+func BumpVersion(c, prev *chart.Chart, prerelease string) (*VersionChange, error) {
+	return bumpVersion(c, prev, prerelease, false)
+}
+
+func bumpVersion(c, prev *chart.Chart, prerelease string, fromDir bool) (*VersionChange, error) {
+	if c.Metadata == nil {
+		return nil, errors.New("chart has no metadata")
+	}
+	current, err := semver.NewVersion(c.Metadata.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid chart version %q", c.Metadata.Version)
+	}
+	if prev == nil || prev.Metadata == nil {
+		if prerelease != "" {
+			v, err := current.SetPrerelease(buildPrerelease(1, prerelease))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid prerelease %q", prerelease)
+			}
+			c.Metadata.Version = v.String()
+		}
+		return &VersionChange{Version: c.Metadata.Version}, nil
+	}
+	previous, err := semver.NewVersion(prev.Metadata.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid version %q of the previous chart", prev.Metadata.Version)
+	}
+	bump, reasons := compareCharts(prev, c, fromDir)
+	change := &VersionChange{Previous: prev.Metadata.Version, Bump: bump, Reasons: reasons}
+	next, err := nextVersion(previous, bump, prerelease)
+	if err != nil {
+		return nil, err
+	}
+	if current.GreaterThan(next) {
+		next = current
+	}
+	change.Version = next.String()
+	c.Metadata.Version = change.Version
+	return change, nil
+}
+
+// nextVersion increments the part of the version, the release of a
+// prerelease already has the increment when the parts below it are 0: it is
+// released without a prerelease, or its build number is incremented. The
+// release of a prerelease without a build number is incremented, its
+// prereleases can't be ordered.
+func nextVersion(v *semver.Version, bump Bump, prerelease string) (*semver.Version, error) {
+	if bump == BumpNone {
+		return v, nil
+	}
+	next, _ := v.SetMetadata("")
+	build := 1
+	if v.Prerelease() != "" {
+		next, _ = next.SetPrerelease("")
+		if bump == BumpPatch || bump == BumpMinor && v.Patch() == 0 || bump == BumpMajor && v.Minor() == 0 && v.Patch() == 0 {
+			if n, ok := buildNumber(v.Prerelease()); ok || prerelease == "" {
+				bump = BumpNone
+				build = n + 1
+			} else {
+				bump = BumpPatch
+			}
+		}
+	}
+	next = increment(next, bump)
+	next, err := next.SetPrerelease(buildPrerelease(build, prerelease))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid prerelease %q", prerelease)
+	}
+	if !next.GreaterThan(v) {
+		return nil, errors.Errorf("the next version %s is not higher than %s", &next, v)
+	}
+	return &next, nil
+}
+
+// buildPrerelease is the prerelease with the build number, empty without one
+func buildPrerelease(build int, prerelease string) string {
+	if prerelease == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d.%s", build, prerelease)
+}
+
+// buildNumber is the build number of a prerelease of buildPrerelease
+func buildNumber(prerelease string) (int, bool) {
+	n, err := strconv.Atoi(strings.SplitN(prerelease, ".", 2)[0])
+	return n, err == nil && n >= 0
+}
+
+func increment(v semver.Version, bump Bump) semver.Version {
+	switch bump {
+	case BumpPatch:
+		return v.IncPatch()
+	case BumpMinor:
+		return v.IncMinor()
+	case BumpMajor:
+		return v.IncMajor()
+	}
+	return v
+}
+
+// CompareCharts tells how a chart changed from the previous chart prev: the
+// apps are the keys of the values and the templates the files of the
+// templates directory.
+//   - an app or a template removed, or a value removed or renamed, is major
+//   - an app or a template added is minor
+//   - a value added or changed, a template, a file or the metadata changed is
+//     a patch
+//
+// A file of prev generated by helm-maker is unchanged while its generated
+// content is, the edits made by hand since are not changes. The reasons are
+// the changes, like "app api added".
+func CompareCharts(prev, c *chart.Chart) (Bump, []string) {
+	return compareCharts(prev, c, false)
+}
+
+// compareCharts compares the charts, fromDir tells prev is the chart
+// directory c is saved into, which keeps its files not generated by helm-maker
+func compareCharts(prev, c *chart.Chart, fromDir bool) (Bump, []string) {
+	bump := BumpNone
+	var reasons []string
+	add := func(b Bump, format string, args ...interface{}) {
+		if b > bump {
+			bump = b
+		}
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+
+	// the values are compared unless both values files have the same content
+	oldValues, values := rawFile(prev, ValuesfileName), rawFile(c, ValuesfileName)
+	if oldValues == nil || values == nil || !unchangedFile(oldValues, values) {
+		for _, app := range sortedKeys(prev.Values) {
+			if _, ok := c.Values[app]; !ok {
+				add(BumpMajor, "app %s removed", app)
+			}
+		}
+		for _, app := range sortedKeys(c.Values) {
+			old, ok := prev.Values[app]
+			if !ok {
+				add(BumpMinor, "app %s added", app)
+				continue
+			}
+			compareValues(app, old, c.Values[app], add)
+		}
+	}
+
+	compareFiles("template", prev.Templates, c.Templates, BumpMajor, BumpMinor, fromDir, add)
+	compareFiles("file", prev.Files, c.Files, BumpPatch, BumpPatch, fromDir, add)
+	if !bytes.Equal(prev.Schema, c.Schema) {
+		add(BumpPatch, "%s changed", SchemafileName)
+	}
+	if prev.Metadata != nil && c.Metadata != nil {
+		old, md := *prev.Metadata, *c.Metadata
+		old.Version, md.Version = "", ""
+		if !reflect.DeepEqual(old, md) {
+			add(BumpPatch, "%s changed", ChartfileName)
+		}
+	}
+	return bump, reasons
+}
+
+// compareValues compares the value of a key path of the previous and of the
+// new values, the lists and the other values are compared as a whole
+func compareValues(p string, old, v interface{}, add func(Bump, string, ...interface{})) {
+	om, ok1 := old.(map[string]interface{})
+	m, ok2 := v.(map[string]interface{})
+	if !ok1 || !ok2 {
+		if !reflect.DeepEqual(old, v) {
+			add(BumpPatch, "value %s changed", p)
+		}
+		return
+	}
+	for _, k := range sortedKeys(om) {
+		if _, ok := m[k]; !ok {
+			add(BumpMajor, "value %s removed", p+"."+k)
+		}
+	}
+	for _, k := range sortedKeys(m) {
+		if _, ok := om[k]; !ok {
+			add(BumpPatch, "value %s added", p+"."+k)
+			continue
+		}
+		compareValues(p+"."+k, om[k], m[k], add)
+	}
+}
+
+// compareFiles compares files by name, an old file without the helm-maker
+// header missing from files is kept when fromDir is set
+func compareFiles(what string, old, files []*chart.File, removed, added Bump, fromDir bool, add func(Bump, string, ...interface{})) {
+	oldData := make(map[string][]byte, len(old))
+	for _, f := range old {
+		oldData[f.Name] = f.Data
+	}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f.Name] = true
+		data, ok := oldData[f.Name]
+		switch {
+		case !ok:
+			add(added, "%s %s added", what, f.Name)
+		case !unchangedFile(data, f.Data):
+			add(BumpPatch, "%s %s changed", what, f.Name)
+		}
+	}
+	var gone []string
+	for name, data := range oldData {
+		if _, _, generated := splitMarker(data); !seen[name] && (generated || !fromDir) {
+			gone = append(gone, name)
+		}
+	}
+	sort.Strings(gone)
+	for _, name := range gone {
+		add(removed, "%s %s removed", what, name)
+	}
+}
+
+// unchangedFile tells whether the generated content of a file is the same,
+// the content of a file with the helm-maker header is compared with the
+// checksum of the header, so its user regions and edits are left out
+func unchangedFile(old, data []byte) bool {
+	_, content, _ := splitMarker(data)
+	if sum, _, ok := splitMarker(old); ok {
+		return checksum(content) == sum
+	}
+	return bytes.Equal(old, content)
+}
+
+// rawFile returns the content of a file of the chart as it was loaded
+func rawFile(c *chart.Chart, name string) []byte {
+	for _, f := range c.Raw {
+		if f.Name == name {
+			return f.Data
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LatestChart returns the latest version of the chart name in a repository
+// index, prereleases included. index is a local index.yaml, or the URL of a
+// repository or of its index.yaml. The chart is nil when the index has no
+// version of it.
+func (h *Helm) LatestChart(index, name string) (*chart.Chart, error) {
+	remote := strings.Contains(index, "://")
+	if remote && !strings.HasSuffix(index, ".yaml") {
+		index = strings.TrimSuffix(index, "/") + "/index.yaml"
+	}
+	data, err := h.fetch(index, remote)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the index %s", index)
+	}
+	idx := new(repo.IndexFile)
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, errors.Wrapf(err, "invalid index %s", index)
+	}
+	idx.SortEntries()
+	cv, err := idx.Get(name, ">0.0.0-0")
+	if err != nil {
+		if errors.Is(err, repo.ErrNoChartName) || errors.Is(err, repo.ErrNoChartVersion) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(cv.URLs) == 0 {
+		return nil, errors.Errorf("chart %s-%s of the index %s has no URL", name, cv.Version, index)
+	}
+	ref := cv.URLs[0]
+	switch {
+	case strings.Contains(ref, "://"):
+		remote = true
+	case remote:
+		base := index[:strings.LastIndex(index, "/")]
+		if ref, err = repo.ResolveReferenceURL(base, ref); err != nil {
+			return nil, err
+		}
+	default:
+		if !filepath.IsAbs(ref) {
+			ref = filepath.Join(filepath.Dir(index), ref)
+		}
+	}
+	archive, err := h.fetch(ref, remote)
+	if err != nil {
+		return nil, errors.Wrapf(err, "downloading %s-%s", name, cv.Version)
+	}
+	return loader.LoadArchive(bytes.NewReader(archive))
+}
+
+// fetch reads a local file or downloads a URL with the getters of helm
+func (h *Helm) fetch(ref string, remote bool) ([]byte, error) {
+	if !remote {
+		return ioutil.ReadFile(ref)
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	g, err := getter.All(h.env).ByScheme(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := g.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
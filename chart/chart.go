@@ -7,6 +7,7 @@ import (
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/helmpath"
+	"os"
 	"path/filepath"
 )
 
@@ -16,7 +17,14 @@ type createOptions struct {
 	starterDir string
 }
 
-func (c *Helm) GenLocalChart(chartName string) (*chart.Chart, error) {
+// GenLocalChart creates the default chart in the directory chartName, its
+// version is bumped from the chart already in the directory, or from the one of
+// WithPrevious, with the prerelease of WithBump. The other options are unused.
+func (c *Helm) GenLocalChart(chartName string, opts ...GenerateOpt) (*chart.Chart, error) {
+	gopts := new(generateOptions)
+	for _, opt := range opts {
+		opt(gopts)
+	}
 	o := &createOptions{}
 	o.name = chartName
 	o.starterDir = helmpath.DataPath("starters")
@@ -44,6 +52,17 @@ func (c *Helm) GenLocalChart(chartName string) (*chart.Chart, error) {
 			return nil, err
 		}
 	}
+	// 已有的chart, 用于升级版本
+	prev := gopts.previous
+	if prev == nil {
+		if _, err := os.Stat(filepath.Join(o.name, ChartfileName)); err == nil {
+			if prev, err = loader.Load(o.name); err != nil {
+				return nil, fmt.Errorf("Load previous err:%s", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Load previous err:%s", err)
+		}
+	}
 	//创建默认
 	_, err := chartutil.Create(chartname, chartpath)
 	if err != nil {
@@ -52,7 +71,7 @@ func (c *Helm) GenLocalChart(chartName string) (*chart.Chart, error) {
 	fmt.Println("----------2", chartpath)
 	// 加载
 
-	helmChart, err := loader.Load(chartname)
+	helmChart, err := loader.Load(o.name)
 	if err != nil {
 		//panic(err)
 		return nil, fmt.Errorf("Load err:%s", err)
@@ -63,7 +82,9 @@ func (c *Helm) GenLocalChart(chartName string) (*chart.Chart, error) {
 	}
 	fmt.Println("----------3")
 	// 修改数据
-	helmChart.Metadata.Version = "0.2.0"
+	if _, err := bumpVersion(helmChart, prev, gopts.prerelease, gopts.previous == nil); err != nil {
+		return nil, fmt.Errorf("Bump err:%s", err)
+	}
 	//helmChart.Metadata.Name = "just-test"
 	//fmt.Printf("--------%+v", helmChart.Values)
 
@@ -75,7 +96,7 @@ func (c *Helm) GenLocalChart(chartName string) (*chart.Chart, error) {
 	//	return nil, fmt.Errorf("Save err:%s", err)
 	//}
 	// 保存为目录
-	err = chartutil.SaveDir(helmChart, ".")
+	err = chartutil.SaveDir(helmChart, chartpath)
 	if err != nil {
 		return nil, fmt.Errorf("SaveDir err:%s", err)
 	}
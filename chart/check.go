@@ -0,0 +1,204 @@
+package chart
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/lint"
+	"helm.sh/helm/v3/pkg/lint/support"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrCheckFailed is the error of a chart whose check found problems of the
+// severity it fails on
+var ErrCheckFailed = errors.New("chart check failed")
+
+// Severity is how bad a problem found checking a chart is
+type Severity int
+
+const (
+	// SeverityNone is below every problem, failing on it never fails
+	SeverityNone Severity = iota
+	// SeverityInfo is a hint, like a missing icon
+	SeverityInfo
+	// SeverityWarning is something which likely works but should be fixed
+	SeverityWarning
+	// SeverityError is something which does not work
+	SeverityError
+)
+
+var severities = []string{"none", "info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severities) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severities[s]
+}
+
+// ParseSeverity parses none, info, warning or error
+func ParseSeverity(s string) (Severity, error) {
+	for i, name := range severities {
+		if strings.EqualFold(s, name) {
+			return Severity(i), nil
+		}
+	}
+	return SeverityNone, errors.Errorf("unknown severity %q, expected one of %s", s, strings.Join(severities, ", "))
+}
+
+// Problem is a problem found checking a chart
+type Problem struct {
+	Severity Severity
+	// Path is the file path relative to the chart directory
+	Path string
+	// Object is the kind and the name of the object, like Service/web
+	Object string
+	// Field is the path of the field in the object, like spec.ports[0].port
+	Field   string
+	Message string
+}
+
+func (p *Problem) String() string {
+	var b strings.Builder
+	b.WriteString("[" + strings.ToUpper(p.Severity.String()) + "] ")
+	for _, s := range []string{p.Path, p.Object, p.Field} {
+		if s != "" {
+			b.WriteString(s + ": ")
+		}
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// CheckOptions are how a chart is checked, the chart is rendered with the
+// RenderOptions to validate its manifests
+type CheckOptions struct {
+	RenderOptions
+	// SchemaFile is the OpenAPI v2 document of a cluster, like the output of
+	// kubectl get --raw /openapi/v2, which is used instead of the bundled
+	// schema of the KubeVersion
+	SchemaFile string
+	// FailOn is the lowest severity which fails the check
+	FailOn Severity
+}
+
+// CheckResult are the problems found checking a chart
+type CheckResult struct {
+	// KubeVersion is the Kubernetes version the manifests are validated for
+	KubeVersion string
+	Problems    []*Problem
+}
+
+// Count returns the number of problems of the severity
+func (r *CheckResult) Count(s Severity) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed tells whether there are problems of the severity or worse, it is
+// never true for SeverityNone
+func (r *CheckResult) Failed(s Severity) bool {
+	if s == SeverityNone {
+		return false
+	}
+	for _, p := range r.Problems {
+		if p.Severity >= s {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns an ErrCheckFailed when there are problems of the severity or worse
+func (r *CheckResult) Err(s Severity) error {
+	if !r.Failed(s) {
+		return nil
+	}
+	return fmt.Errorf("%w: %d errors, %d warnings", ErrCheckFailed, r.Count(SeverityError), r.Count(SeverityWarning))
+}
+
+func (r *CheckResult) String() string {
+	var b strings.Builder
+	for _, p := range r.Problems {
+		b.WriteString(p.String() + "\n")
+	}
+	fmt.Fprintf(&b, "%d errors, %d warnings, %d infos\n", r.Count(SeverityError), r.Count(SeverityWarning), r.Count(SeverityInfo))
+	return b.String()
+}
+
+// Check checks a chart directory, like the one written by ChartsFile. The
+// chart is linted with the rules of helm lint, then every manifest it renders
+// is validated offline against the OpenAPI schema of the Kubernetes version:
+// the bundled schema of opts.KubeVersion, v1.21 when it is empty, or the
+// document opts.SchemaFile. A version without a bundled schema is validated
+// with the nearest bundled one, the kinds and the fields that schema misses
+// are warnings. The problems are returned with their file, object and field,
+// the error is an ErrCheckFailed when there are problems of the severity
+// opts.FailOn or worse.
+func (h *Helm) Check(chartPath string, opts CheckOptions) (*CheckResult, error) {
+	doc, kubeVersion, fallback, err := loadOpenAPI(opts.SchemaFile, opts.KubeVersion)
+	if err != nil {
+		return nil, err
+	}
+	opts.KubeVersion = kubeVersion
+	vals, err := h.renderValues(opts.RenderOptions)
+	if err != nil {
+		return nil, err
+	}
+	r := &CheckResult{KubeVersion: kubeVersion}
+	if fallback != "" {
+		r.Problems = append(r.Problems, &Problem{Severity: SeverityWarning,
+			Message: fmt.Sprintf("no bundled OpenAPI schema of Kubernetes %s, the manifests are validated with the schema of Kubernetes %s", kubeVersion, fallback)})
+	}
+
+	linter := lint.All(chartPath, vals, h.namespace(opts.Namespace), false)
+	for _, msg := range linter.Messages {
+		p := &Problem{Path: msg.Path, Message: msg.Err.Error()}
+		switch msg.Severity {
+		case support.ErrorSev:
+			p.Severity = SeverityError
+		case support.WarningSev:
+			p.Severity = SeverityWarning
+		default:
+			p.Severity = SeverityInfo
+		}
+		r.Problems = append(r.Problems, p)
+	}
+
+	c, err := loader.Load(chartPath)
+	if err != nil {
+		// lint reported why
+		return r, r.Err(opts.FailOn)
+	}
+	rendered, err := h.RenderChart(c, opts.RenderOptions)
+	if err != nil {
+		r.Problems = append(r.Problems, &Problem{Severity: SeverityError, Message: err.Error()})
+		return r, r.Err(opts.FailOn)
+	}
+	for _, file := range rendered.Files() {
+		rel := strings.TrimPrefix(file, c.Name()+"/")
+		for _, m := range releaseutil.SplitManifests(rendered.Manifests[file]) {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(m), &obj); err != nil || obj == nil {
+				continue
+			}
+			object := fmt.Sprint(obj["kind"])
+			if meta, ok := obj["metadata"].(map[string]interface{}); ok {
+				object = path.Join(object, fmt.Sprint(meta["name"]))
+			}
+			doc.validate(obj, kubeVersion, fallback, func(sev Severity, field, msg string) {
+				r.Problems = append(r.Problems, &Problem{Severity: sev, Path: rel, Object: object, Field: field, Message: msg})
+			})
+		}
+	}
+	return r, r.Err(opts.FailOn)
+}
@@ -26,9 +26,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
@@ -46,6 +48,8 @@ const (
 	ChartfileName = "Chart.yaml"
 	// ValuesfileName is the default values file name.
 	ValuesfileName = "values.yaml"
+	// EnvValuesfileName is the name of the values file of an environment.
+	EnvValuesfileName = "values-%s.yaml"
 	// SchemafileName is the default values schema file name.
 	SchemafileName = "values.schema.json"
 	// TemplatesDir is the relative directory name for templates.
@@ -66,6 +70,8 @@ const (
 	ServiceAccountName = TemplatesDir + sep + "serviceaccount.yaml"
 	// HorizontalPodAutoscalerName is the name of the example hpa file.
 	HorizontalPodAutoscalerName = TemplatesDir + sep + "hpa.yaml"
+	// NotesFileName is the name of the notes file in the templates directory.
+	NotesFileName = "NOTES.txt"
 	// NotesName is the name of the example NOTES.txt file.
 	NotesName = TemplatesDir + sep + "NOTES.txt"
 	// HelpersName is the name of the example helpers file.
@@ -144,17 +150,20 @@ func writeFileAppend(name string, content []byte) error {
 	}
 	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		fmt.Println("文件打开失败", err)
 		return err
 	}
-	//及时关闭file句柄
-	defer file.Close()
 	//写入文件时，使用带缓存的 *Writer
 	write := bufio.NewWriter(file)
-	write.Write(content)
+	if _, err := write.Write(content); err != nil {
+		file.Close()
+		return err
+	}
 	//Flush将缓存的文件真正写入到文件中
-	write.Flush()
-	return err
+	if err := write.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 func validateChartName(name string) error {
@@ -176,6 +185,16 @@ type TemplateModel struct {
 
 type Model map[string]TemplateModel
 
+// Kinds returns the sorted names of the template kinds
+func (m Model) Kinds() []string {
+	kinds := make([]string, 0, len(m))
+	for k := range m {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
 var model = Model{
 	"deployment": TemplateModel{
 		FileName: "deployment_%s.yaml",
@@ -192,24 +211,129 @@ var model = Model{
 		Content:  defaultService,
 		Type:     "service",
 	},
-	"pv":  TemplateModel{},
-	"pvc": TemplateModel{},
-	"set": TemplateModel{},
+	"pv": TemplateModel{
+		FileName: "pv_%s.yaml",
+		Content:  defaultPersistentVolume,
+		Type:     "pv",
+	},
+	"pvc": TemplateModel{
+		FileName: "pvc_%s.yaml",
+		Content:  defaultPersistentVolumeClaim,
+		Type:     "pvc",
+	},
+	"set": TemplateModel{
+		FileName: "set_%s.yaml",
+		Content:  defaultStatefulSet,
+		Type:     "set",
+	},
+	"job": TemplateModel{
+		FileName: "job_%s.yaml",
+		Content:  defaultJob,
+		Type:     "job",
+	},
+	"cronjob": TemplateModel{
+		FileName: "cronjob_%s.yaml",
+		Content:  defaultCronJob,
+		Type:     "cronjob",
+	},
+	"daemonset": TemplateModel{
+		FileName: "daemonset_%s.yaml",
+		Content:  defaultDaemonSet,
+		Type:     "daemonset",
+	},
+	"configmap": TemplateModel{
+		FileName: "configmap_%s.yaml",
+		Content:  defaultConfigMap,
+		Type:     "configmap",
+	},
+	"secret": TemplateModel{
+		FileName: "secret_%s.yaml",
+		Content:  defaultSecret,
+		Type:     "secret",
+	},
+	"ingress": TemplateModel{
+		FileName: "ingress_%s.yaml",
+		Content:  defaultIngress,
+		Type:     "ingress",
+	},
+	"hpa": TemplateModel{
+		FileName: "hpa_%s.yaml",
+		Content:  defaultHorizontalPodAutoscaler,
+		Type:     "hpa",
+	},
+	"serviceaccount": TemplateModel{
+		FileName: "serviceaccount_%s.yaml",
+		Content:  defaultServiceAccount,
+		Type:     "serviceaccount",
+	},
+	"test": TemplateModel{
+		FileName: "tests/test-connection_%s.yaml",
+		Content:  defaultTestConnection,
+		Type:     "test",
+	},
+}
+
+// HasType reports whether the app uses one of the template kinds
+func (a *App) HasType(kinds ...string) bool {
+	for _, t := range a.Types {
+		for _, k := range kinds {
+			if t == k {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // 单个应用
 type App struct {
-	Name   string
-	Types  []string
-	Values map[string]interface{}
+	Name   string                 `json:"name"`
+	Types  []string               `json:"types"`
+	Values map[string]interface{} `json:"values,omitempty"`
+	// Templates are templates written as they are, file name -> content
+	Templates map[string]string `json:"templates,omitempty"`
+	// Schema are JSON schema keywords merged into the schema inferred from the
+	// values, value path like value.ports[].name -> keywords
+	Schema map[string]map[string]interface{} `json:"schema,omitempty"`
+	// valuesNode is the node of the values in the spec, their key order and
+	// comments are kept in values.yaml
+	valuesNode *yamlv3.Node
 }
 
 // 组合应用
 type Apps struct {
-	Name    string
-	Path    string
-	Sets    []*App
-	Version string
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Sets    []*App `json:"apps"`
+	Version string `json:"version,omitempty"`
+	// Envs are the values overrides of each environment, env -> app name -> values,
+	// written to values-<env>.yaml
+	Envs map[string]map[string]interface{} `json:"envs,omitempty"`
+	// Metadata is the metadata of the chart written to Chart.yaml
+	Metadata *ChartMetadata `json:"metadata,omitempty"`
+	// BaseValues is a hand-written values file the values of the apps are
+	// merged into, the spec gives its path as baseValues
+	BaseValues []byte `json:"-"`
+}
+
+// ChartMetadata is the metadata of the chart written to Chart.yaml, the
+// version of the chart is Apps.Version
+type ChartMetadata struct {
+	Description string `json:"description,omitempty"`
+	// AppVersion is the default version of the apps, the version label of
+	// the apps without values.version
+	AppVersion string `json:"appVersion,omitempty"`
+	// KubeVersion is the semver constraint of the Kubernetes versions the
+	// chart can be installed on, like >=1.21.0-0
+	KubeVersion string `json:"kubeVersion,omitempty"`
+	// Type is application or library
+	Type        string              `json:"type,omitempty"`
+	Keywords    []string            `json:"keywords,omitempty"`
+	Home        string              `json:"home,omitempty"`
+	Sources     []string            `json:"sources,omitempty"`
+	Maintainers []*chart.Maintainer `json:"maintainers,omitempty"`
+	Icon        string              `json:"icon,omitempty"`
+	Annotations map[string]string   `json:"annotations,omitempty"`
 }
 
 // 构建单应用的部署文件
@@ -229,102 +353,85 @@ func WriteTplFile(apps *Apps, app *App, dir string) (string, error) {
 		return path, errors.Errorf("no such directory %s", path)
 	}
 
-	for _, t := range app.Types {
-		m, ok := model[t]
-		if !ok {
-			continue
-		}
-		path := filepath.Join(path, fmt.Sprintf(m.FileName, app.Name))
+	files, err := appTemplateFiles(apps, app)
+	if err != nil {
+		return path, err
+	}
+	for _, f := range files {
+		path := filepath.Join(path, filepath.FromSlash(f.Name))
 		if _, err := os.Stat(path); err == nil {
 			// There is no handle to a preferred output stream here.
 			fmt.Fprintf(Stderr, "WARNING: File %q already exists. Overwriting.\n", path)
 		}
-		if strings.Contains(m.Content, APPNAME) {
-			m.Content = strings.ReplaceAll(m.Content, APPNAME, app.Name)
-		}
-		if strings.Contains(m.Content, CHARTNAME) {
-			m.Content = strings.ReplaceAll(m.Content, CHARTNAME, apps.Name)
-		}
-		if err := writeFile(path, []byte(m.Content)); err != nil {
-			return path, err
+		if err := writeFile(path, f.Data); err != nil {
+			return path, &GenerateError{App: app.Name, Kind: f.kind, Path: path, Err: err}
 		}
 	}
 	return "", err
 }
 
-// 构建value.yaml文件
+// 构建value.yaml文件, defaultContent 是手写的基础 values 文件, 应用的 values
+// 合并进去并保留它的注释, 为 nil 时使用 apps.BaseValues
 func WriteValueFile(path string, apps *Apps, defaultContent []byte) error {
-	var err error
-	var content [][]byte
-	appValue := make(map[string]interface{})
-	if defaultContent != nil {
-		content = append(content, defaultContent)
-	}
-	for _, app := range apps.Sets {
-		appValue[app.Name] = app.Values
+	if defaultContent == nil {
+		defaultContent = apps.BaseValues
 	}
-	value, err := yaml.Marshal(appValue)
-	content = append(content, value)
-	if err := writeFile(filepath.Join(path, ValuesfileName), bytes.Join(content, []byte("\n"))); err != nil {
-		fmt.Println("create Value.yaml err:", err)
-	}
-	return err
-}
-
-// 构建_helpers.tpl
-func WriteHelperFile(path, defaultHelpers string, app *App, apps *Apps) error {
-	var err error
-	content := strings.ReplaceAll(defaultHelpers, APPNAME, app.Name)
-	if err := writeFileAppend(path, transform(content, apps.Name)); err != nil {
-		fmt.Println("create Helpers.tpl err:", err)
+	content, err := valuesContent(apps, defaultContent)
+	if err != nil {
+		return err
 	}
-	return err
+	return writeFile(filepath.Join(path, ValuesfileName), content)
 }
 
-// 构建多个应用的部署文件
-func ChartsFile(apps *Apps) (string, error) {
-
-	if err := validateChartName(apps.Name); err != nil {
-		return "", err
-	}
-
-	path, err := filepath.Abs(apps.Path)
+// 构建values-<env>.yaml文件
+func WriteEnvValueFiles(path string, apps *Apps) error {
+	files, err := envValuesContents(apps)
 	if err != nil {
-		return path, err
+		return err
 	}
-
-	if fi, err := os.Stat(path); err != nil {
-		return path, err
-	} else if !fi.IsDir() {
-		return path, errors.Errorf("no such directory %s", path)
+	for _, f := range files {
+		if err := writeFile(filepath.Join(path, f.Name), f.Data); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	cdir := filepath.Join(path, apps.Name)
-	if fi, err := os.Stat(cdir); err == nil && !fi.IsDir() {
-		return cdir, errors.Errorf("file %s already exists and is not app directory", cdir)
-	}
-	templatesDir := filepath.Join(cdir, TemplatesDir)
-	// create helpers.tpl for all templates
-	if err := writeFile(templatesDir+HelpersName, transform(defaultHelpers, apps.Name)); err != nil {
-		fmt.Println("chartsFile Chart.yaml err:", err)
+// 构建_helpers.tpl, 已有的应用部分会被替换而不是重复追加
+func WriteHelperFile(path, defaultHelpers string, app *App, apps *Apps) error {
+	section := transform(strings.ReplaceAll(appHelpersBegin+defaultHelpers+appHelpersEnd, APPNAME, app.Name), apps.Name)
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return &GenerateError{App: app.Name, Path: path, Err: err}
 	}
-	for _, app := range apps.Sets {
-		// create helpers.tpl for app templates
-		WriteHelperFile(templatesDir+HelpersName, defaultAppHelpers, app, apps)
-		_, err := WriteTplFile(apps, app, templatesDir)
-		if err != nil {
-			fmt.Println("chartsFile err:", err)
-			break
+	begin := bytes.Index(old, []byte(strings.ReplaceAll(appHelpersBegin, APPNAME, app.Name)))
+	end := bytes.Index(old, []byte(strings.ReplaceAll(appHelpersEnd, APPNAME, app.Name)))
+	if begin < 0 || end < begin {
+		if err := writeFileAppend(path, section); err != nil {
+			return &GenerateError{App: app.Name, Path: path, Err: err}
 		}
+		return nil
+	}
+	end += len(strings.ReplaceAll(appHelpersEnd, APPNAME, app.Name))
+	content := append(append(append([]byte{}, old[:begin]...), section...), old[end:]...)
+	if err := writeFile(path, content); err != nil {
+		return &GenerateError{App: app.Name, Path: path, Err: err}
 	}
+	return nil
+}
+
+// 构建NOTES.txt, 列出每个应用的访问方式
+func WriteNotesFile(path string, apps *Apps) error {
+	return writeFile(path, notesContent(apps))
+}
 
-	// create value.yaml
-	WriteValueFile(cdir, apps, nil)
-	// Chart.yaml
-	if err := writeFile(filepath.Join(cdir, ChartfileName), transform(fmt.Sprintf(defaultChartfile, apps.Name), apps.Name)); err != nil {
-		fmt.Println("chartsFile Chart.yaml err:", err)
+// 构建多个应用的部署文件, 见 Generate
+func ChartsFile(apps *Apps) (string, error) {
+	r, err := Generate(apps)
+	if r == nil {
+		return "", err
 	}
-	return "", err
+	return r.Dir, err
 }
 
 func InitApps() *Apps {
@@ -0,0 +1,167 @@
+package chart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// ChartCacheDir is the directory of the repository cache the archives of
+// the dependencies are cached in
+const ChartCacheDir = "charts"
+
+// DependencyOptions are how the dependencies of a chart are resolved into its
+// charts directory
+type DependencyOptions struct {
+	// Update resolves the dependencies of Chart.yaml again and rewrites
+	// Chart.lock, like helm dependency update, even when they are in the
+	// charts directory. Otherwise the missing dependencies are built from
+	// Chart.lock, like helm dependency build, or updated without one.
+	Update bool
+	// Offline downloads nothing: the indexes of the repositories are the
+	// cached ones and the archives come from the cache of the archives
+	// downloaded before, see ChartCacheDir
+	Offline bool
+	// Keyring verifies the archives with their provenance files when set
+	Keyring string
+}
+
+// BuildDependencies resolves the dependencies of a chart directory into its
+// charts directory and returns the chart loaded again with them. Nothing is
+// resolved when they are all in the charts directory, unless the options
+// update them. The dependencies of a chart archive can't be resolved, they
+// must be in it.
+func (h *Helm) BuildDependencies(chartPath string, opts DependencyOptions) (*chart.Chart, error) {
+	c, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.Metadata.Dependencies == nil || !opts.Update && checkDependencies(c) == nil {
+		return c, nil
+	}
+	if fi, err := os.Stat(chartPath); err != nil || !fi.IsDir() {
+		if err := checkDependencies(c); err != nil {
+			return nil, errors.Wrapf(err, "the dependencies of the chart archive %s can't be resolved", chartPath)
+		}
+		return c, nil
+	}
+	man := &downloader.Manager{
+		Out:              logWriter(h.logger),
+		ChartPath:        chartPath,
+		Keyring:          opts.Keyring,
+		SkipUpdate:       opts.Offline,
+		Getters:          h.dependencyGetters(opts.Offline),
+		RepositoryConfig: h.env.RepositoryConfig,
+		RepositoryCache:  h.env.RepositoryCache,
+	}
+	if opts.Keyring != "" {
+		man.Verify = downloader.VerifyAlways
+	}
+	if opts.Update {
+		err = man.Update()
+	} else {
+		err = man.Build()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving the dependencies of %s", chartPath)
+	}
+	// the chart is loaded again with the dependencies
+	if c, err = loader.Load(chartPath); err != nil {
+		return nil, err
+	}
+	if err := checkDependencies(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkDependencies checks the dependencies of the chart are in its charts
+func checkDependencies(c *chart.Chart) error {
+	if c.Metadata == nil {
+		return errors.New("chart has no metadata")
+	}
+	if req := c.Metadata.Dependencies; req != nil {
+		return action.CheckDependencies(c, req)
+	}
+	return nil
+}
+
+// logWriter writes to a logger, like the output of the dependency manager
+type logWriter func(format string, args ...interface{})
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w("%s", p)
+	return len(p), nil
+}
+
+// dependencyGetters are the getters of helm whose chart archives are cached
+// in ChartCacheDir, offline they only read the cache
+func (h *Helm) dependencyGetters(offline bool) getter.Providers {
+	dir := filepath.Join(h.env.RepositoryCache, ChartCacheDir)
+	var providers getter.Providers
+	for _, p := range getter.All(h.env) {
+		p := p
+		providers = append(providers, getter.Provider{
+			Schemes: p.Schemes,
+			New: func(options ...getter.Option) (getter.Getter, error) {
+				g, err := p.New(options...)
+				if err != nil {
+					return nil, err
+				}
+				return &cachedGetter{getter: g, dir: dir, offline: offline}, nil
+			},
+		})
+	}
+	return providers
+}
+
+// cachedGetter keeps the chart archives it downloads in dir and reads them
+// from dir when they are there, the chart archives of a version don't change
+type cachedGetter struct {
+	getter  getter.Getter
+	dir     string
+	offline bool
+}
+
+func (g *cachedGetter) Get(ref string, options ...getter.Option) (*bytes.Buffer, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, ".tgz") {
+		if g.offline {
+			return nil, errors.Errorf("offline, %s is not downloaded", ref)
+		}
+		return g.getter.Get(ref, options...)
+	}
+	sum := sha256.Sum256([]byte(ref))
+	file := filepath.Join(g.dir, hex.EncodeToString(sum[:8])+"-"+path.Base(u.Path))
+	if data, err := ioutil.ReadFile(file); err == nil {
+		return bytes.NewBuffer(data), nil
+	}
+	if g.offline {
+		return nil, errors.Errorf("offline, %s is not in the cache %s", ref, g.dir)
+	}
+	buf, err := g.getter.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	// a chart which is not cached is downloaded again next time
+	if err := os.MkdirAll(g.dir, 0755); err == nil {
+		_ = ioutil.WriteFile(file, buf.Bytes(), 0644)
+	}
+	return buf, nil
+}
@@ -0,0 +1,149 @@
+package chart
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// DiffStatus is how a file of a chart directory would change
+type DiffStatus string
+
+const (
+	// DiffAdded is a file which would be created
+	DiffAdded DiffStatus = "added"
+	// DiffChanged is a file whose content would change
+	DiffChanged DiffStatus = "changed"
+	// DiffRemoved is a file which would be removed
+	DiffRemoved DiffStatus = "removed"
+	// DiffEdited is a file edited by hand or not generated by helm-maker which
+	// would be kept, it differs from the generated one
+	DiffEdited DiffStatus = "edited"
+)
+
+// FileDiff is a file of a chart directory which would change
+type FileDiff struct {
+	// Path is the file path relative to the chart directory
+	Path   string     `json:"path"`
+	App    string     `json:"app,omitempty"`
+	Kind   string     `json:"kind,omitempty"`
+	Status DiffStatus `json:"status"`
+	// Conflict is set on a changed file which was edited by hand
+	Conflict bool `json:"conflict,omitempty"`
+	// Reason is why an edited file would be kept
+	Reason string `json:"reason,omitempty"`
+	// Diff is the unified diff of the file
+	Diff string `json:"-"`
+}
+
+// DiffReport lists the files of a chart directory which generating the chart
+// would change
+type DiffReport struct {
+	// Dir is the chart directory
+	Dir     string      `json:"dir"`
+	Drift   bool        `json:"drift"`
+	Added   int         `json:"added"`
+	Changed int         `json:"changed"`
+	Removed int         `json:"removed"`
+	Edited  int         `json:"edited"`
+	Files   []*FileDiff `json:"files"`
+	// Version is how the chart version is bumped, see WithBump
+	Version *VersionChange `json:"version,omitempty"`
+}
+
+// String returns the unified diff of all the files
+func (d *DiffReport) String() string {
+	var b strings.Builder
+	for _, f := range d.Files {
+		b.WriteString(f.Diff)
+	}
+	return b.String()
+}
+
+// Diff builds the chart of the apps in memory and compares it with the chart
+// directory in apps.Path, nothing is written. The report has the files
+// Generate would add, change or remove with the same options. Files edited by
+// hand or not generated by helm-maker, which Generate keeps, are drift too:
+// they are reported as edited with the diff to the generated file, or as
+// changed with WithForce. A file edited by hand whose generated content changed
+// is reported as a changed conflict.
+func Diff(apps *Apps, opts ...GenerateOpt) (*DiffReport, error) {
+	path, err := filepath.Abs(apps.Path)
+	if err != nil {
+		return nil, err
+	}
+	c, origins, err := buildChart(apps)
+	if err != nil {
+		return nil, err
+	}
+	return diffChart(c, path, origins, opts)
+}
+
+// DiffChart compares the chart with the chart directory dir/<chart name> like Diff
+func DiffChart(c *chart.Chart, dir string, opts ...GenerateOpt) (*DiffReport, error) {
+	return diffChart(c, dir, nil, opts)
+}
+
+func diffChart(c *chart.Chart, dir string, origins map[string]origin, opts []GenerateOpt) (*DiffReport, error) {
+	plan, err := planChart(c, dir, origins, opts)
+	if err != nil {
+		return nil, err
+	}
+	d := &DiffReport{Dir: plan.dir, Files: []*FileDiff{}, Version: plan.report.Version}
+	for _, p := range plan.files {
+		f := &FileDiff{Path: p.name, App: p.report.App, Kind: p.report.Kind}
+		data := p.data
+		switch p.report.Action {
+		case FileCreated:
+			f.Status = DiffAdded
+			d.Added++
+		case FileOverwritten:
+			f.Status = DiffChanged
+			d.Changed++
+		case FileConflict:
+			data = p.forced
+			f.Status, f.Conflict = DiffChanged, true
+			d.Changed++
+		case FileRemoved:
+			f.Status = DiffRemoved
+			d.Removed++
+		case FileSkipped:
+			if p.data != nil {
+				// unchanged
+				continue
+			}
+			data = p.forced
+			f.Status, f.Reason = DiffEdited, p.report.Reason
+			d.Edited++
+		default:
+			continue
+		}
+		if f.Diff, err = unifiedDiff(p.name, p.old, data); err != nil {
+			return nil, errors.Wrapf(err, "diff %s", p.name)
+		}
+		d.Files = append(d.Files, f)
+	}
+	d.Drift = len(d.Files) > 0
+	return d, nil
+}
+
+// unifiedDiff returns the unified diff of a file from old to new content, a nil
+// content is a file which does not exist
+func unifiedDiff(name string, old, new []byte) (string, error) {
+	u := difflib.UnifiedDiff{FromFile: "a/" + name, ToFile: "b/" + name, Context: 3}
+	if old == nil {
+		u.FromFile = os.DevNull
+	} else {
+		u.A = difflib.SplitLines(string(old))
+	}
+	if new == nil {
+		u.ToFile = os.DevNull
+	} else {
+		u.B = difflib.SplitLines(string(new))
+	}
+	return difflib.GetUnifiedDiffString(u)
+}
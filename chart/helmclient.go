@@ -8,8 +8,6 @@ import (
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/cli"
-	"helm.sh/helm/v3/pkg/cli/values"
-	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/helmpath"
 	"helm.sh/helm/v3/pkg/release"
@@ -17,6 +15,7 @@ import (
 	"helm.sh/helm/v3/pkg/storage/driver"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // StableCharts is a helm repo entry for the standard stable helm charts: https://charts.helm.sh/stable
@@ -27,9 +26,11 @@ var StableCharts = &repo.Entry{
 
 // Helm is a v3 helm client(wrapper)
 type Helm struct {
-	env    *cli.EnvSettings
-	repo   *repo.File
-	logger func(format string, args ...interface{})
+	env        *cli.EnvSettings
+	repo       *repo.File
+	logger     func(format string, args ...interface{})
+	valueFiles []string
+	config     *action.Configuration
 }
 
 // HelmOpt is an optional argument to modify the helm client
@@ -49,6 +50,22 @@ func WithEnvFunc(fn func(settings *cli.EnvSettings)) HelmOpt {
 	}
 }
 
+// WithValueFiles adds values files merged into every install, upgrade, lint and template
+func WithValueFiles(files ...string) HelmOpt {
+	return func(h *Helm) {
+		h.valueFiles = append(h.valueFiles, files...)
+	}
+}
+
+// WithActionConfig runs the release actions with config instead of a
+// configuration of the kube config, like a configuration with the memory
+// driver and a fake kube client in tests
+func WithActionConfig(config *action.Configuration) HelmOpt {
+	return func(h *Helm) {
+		h.config = config
+	}
+}
+
 // NewHelm creates a new v3 helm client(wrapper).
 func NewHelm(opts ...HelmOpt) (*Helm, error) {
 	h := &Helm{
@@ -64,11 +81,20 @@ func NewHelm(opts ...HelmOpt) (*Helm, error) {
 	return h, nil
 }
 
+// namespace falls back to the namespace of the kube config when ns is empty
+func (h *Helm) namespace(ns string) string {
+	if ns == "" {
+		return h.env.Namespace()
+	}
+	return ns
+}
+
 func (h *Helm) actionConfig(namespace string) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
-	if namespace == "" {
-		namespace = h.env.Namespace()
+	if h.config != nil {
+		return h.config, nil
 	}
+	actionConfig := new(action.Configuration)
+	namespace = h.namespace(namespace)
 	if err := actionConfig.Init(h.env.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), h.logger); err != nil {
 		return nil, err
 	}
@@ -86,38 +112,64 @@ func (h *Helm) Get(namespace string, name string) (*release.Release, error) {
 	return client.Run(name)
 }
 
-// Upgrade upgrades a chart in the cluster
-func (h *Helm) Upgrade(namespace string, chartName, releaseName string, recreate bool, configVals map[string]string) (*release.Release, error) {
-	config, err := h.actionConfig(namespace)
+// Upgrade upgrades a chart in the cluster with the options, see UpgradeOptions
+func (h *Helm) Upgrade(namespace string, chartName, releaseName string, opts UpgradeOptions) (*release.Release, error) {
+	upgrade, vals, err := h.newUpgrade(namespace, opts)
 	if err != nil {
 		return nil, err
 	}
-	upgrade := action.NewUpgrade(config)
-	if upgrade.Version == "" {
-		upgrade.Version = ">0.0.0-0"
-	}
-	upgrade.Namespace = namespace
-	upgrade.Recreate = recreate
-	upgrade.Wait = true
-	getters := getter.All(h.env)
-	valueOpts := &values.Options{}
-	vals, err := valueOpts.MergeValues(getters)
+	chrt, cp, err := h.getLocalChart(chartName, &upgrade.ChartPathOptions)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range configVals {
-		vals[k] = v
+	if opts.Dependencies.Update || checkDependencies(chrt) != nil {
+		if chrt, err = h.BuildDependencies(cp, opts.Dependencies); err != nil {
+			return nil, err
+		}
 	}
-	chrt, _, err := h.getLocalChart(chartName, &upgrade.ChartPathOptions)
+	return upgrade.Run(releaseName, chrt, vals)
+}
+
+// UpgradeChart upgrades the release to a chart in memory, like the chart of
+// BuildChart, see Upgrade. Its dependencies must be in its charts.
+func (h *Helm) UpgradeChart(namespace string, c *chart.Chart, releaseName string, opts UpgradeOptions) (*release.Release, error) {
+	upgrade, vals, err := h.newUpgrade(namespace, opts)
 	if err != nil {
 		return nil, err
 	}
-	if req := chrt.Metadata.Dependencies; req != nil {
-		if err := action.CheckDependencies(chrt, req); err != nil {
-			return nil, err
-		}
+	if err := checkDependencies(c); err != nil {
+		return nil, err
 	}
-	return upgrade.Run(releaseName, chrt, vals)
+	return upgrade.Run(releaseName, c, vals)
+}
+
+// UpgradeApps upgrades the release to the chart of the apps, nothing is
+// written to disk, see UpgradeChart
+func (h *Helm) UpgradeApps(namespace string, apps *Apps, releaseName string, opts UpgradeOptions) (*release.Release, error) {
+	c, err := BuildChart(apps)
+	if err != nil {
+		return nil, err
+	}
+	return h.UpgradeChart(namespace, c, releaseName, opts)
+}
+
+// newUpgrade is the upgrade action of the options and the values of the release
+func (h *Helm) newUpgrade(namespace string, opts UpgradeOptions) (*action.Upgrade, map[string]interface{}, error) {
+	if err := checkLabels(opts.Labels); err != nil {
+		return nil, nil, err
+	}
+	config, err := h.actionConfig(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	upgrade := action.NewUpgrade(config)
+	opts.apply(upgrade)
+	upgrade.Namespace = h.namespace(namespace)
+	vals, err := h.MergeValues(opts.ValueOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return upgrade, vals, nil
 }
 
 // IsInstalled checks whether a release/chart is already installed on the cluster
@@ -136,51 +188,65 @@ func (h *Helm) IsInstalled(namespace string, release string) (bool, error) {
 	return true, nil
 }
 
-// Install a chart/release in the given namespace
-func (h *Helm) Install(namespace, chartName, releaseName string, createNamespace bool, configVals map[string]string) (*release.Release, error) {
-	config, err := h.actionConfig(namespace)
+// Install a chart/release in the given namespace with the options, see InstallOptions
+func (h *Helm) Install(namespace, chartName, releaseName string, opts InstallOptions) (*release.Release, error) {
+	client, vals, err := h.newInstall(namespace, releaseName, opts)
 	if err != nil {
 		return nil, err
 	}
-	client := action.NewInstall(config)
-	if client.Version == "" {
-		client.Version = ">0.0.0-0"
+	chrt, cp, err := h.getLocalChart(chartName, &client.ChartPathOptions)
+	if err != nil {
+		return nil, err
 	}
-	client.Namespace = namespace
-	client.CreateNamespace = createNamespace
-	client.IncludeCRDs = true
-	client.Wait = true
-	client.ReleaseName = releaseName
-	getters := getter.All(h.env)
-	valueOpts := &values.Options{}
-	vals, err := valueOpts.MergeValues(getters)
+	if opts.Dependencies.Update || checkDependencies(chrt) != nil {
+		if chrt, err = h.BuildDependencies(cp, opts.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+	return client.Run(chrt, vals)
+}
+
+// InstallChart installs a chart in memory, like the chart of BuildChart, see
+// Install. Its dependencies must be in its charts.
+func (h *Helm) InstallChart(namespace string, c *chart.Chart, releaseName string, opts InstallOptions) (*release.Release, error) {
+	client, vals, err := h.newInstall(namespace, releaseName, opts)
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range configVals {
-		vals[k] = v
+	if err := checkDependencies(c); err != nil {
+		return nil, err
 	}
-	chrt, cp, err := h.getLocalChart(chartName, &client.ChartPathOptions)
+	return client.Run(c, vals)
+}
+
+// InstallApps installs the chart of the apps, nothing is written to disk, see
+// InstallChart
+func (h *Helm) InstallApps(namespace string, apps *Apps, releaseName string, opts InstallOptions) (*release.Release, error) {
+	c, err := BuildChart(apps)
 	if err != nil {
 		return nil, err
 	}
-	if req := chrt.Metadata.Dependencies; req != nil {
-		if err := action.CheckDependencies(chrt, req); err != nil {
-			man := &downloader.Manager{
-				ChartPath:        cp,
-				Keyring:          client.ChartPathOptions.Keyring,
-				SkipUpdate:       false,
-				Getters:          getters,
-				RepositoryConfig: h.env.RepositoryConfig,
-				RepositoryCache:  h.env.RepositoryCache,
-			}
-			if err := man.Update(); err != nil {
-				return nil, err
-			}
-			return nil, err
-		}
+	return h.InstallChart(namespace, c, releaseName, opts)
+}
+
+// newInstall is the install action of the options and the values of the release
+func (h *Helm) newInstall(namespace, releaseName string, opts InstallOptions) (*action.Install, map[string]interface{}, error) {
+	if err := checkLabels(opts.Labels); err != nil {
+		return nil, nil, err
 	}
-	return client.Run(chrt, vals)
+	config, err := h.actionConfig(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := action.NewInstall(config)
+	opts.apply(client)
+	client.Namespace = h.namespace(namespace)
+	client.ReleaseName = releaseName
+	vals, err := h.MergeValues(opts.ValueOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, vals, nil
 }
 
 // Uninstall uninstalls a release by name in the given namespace
@@ -205,14 +271,15 @@ func (h *Helm) History(namespace string, release string, max int) ([]*release.Re
 	return histClient.Run(release)
 }
 
-// Rollback rolls back the chart by name to the previous version
-func (h *Helm) Rollback(namespace string, release string) error {
+// Rollback rolls back the release by name to a revision, the previous one by
+// default, see RollbackOptions
+func (h *Helm) Rollback(namespace string, release string, opts RollbackOptions) error {
 	config, err := h.actionConfig(namespace)
 	if err != nil {
 		return err
 	}
 	client := action.NewRollback(config)
-	client.Recreate = true
+	opts.apply(client)
 	return client.Run(release)
 }
 
@@ -248,6 +315,8 @@ func (h *Helm) AddRepo(entry *repo.Entry) error {
 	if err != nil {
 		return err
 	}
+	// the index is cached where the dependencies are resolved from
+	r.CachePath = h.env.RepositoryCache
 	if _, err := r.DownloadIndexFile(); err != nil {
 		return err
 	}
@@ -271,6 +340,7 @@ func (h *Helm) UpdateRepos() error {
 		if err != nil {
 			return err
 		}
+		r.CachePath = h.env.RepositoryCache
 		if _, err := r.DownloadIndexFile(); err != nil {
 			return err
 		}
@@ -330,6 +400,64 @@ func (h *Helm) AllCharts() ([]*search.Result, error) {
 	return i.All(), nil
 }
 
+// Lint runs helm's lint rules against a local chart directory or archive
+func (h *Helm) Lint(namespace, chartPath string, strict bool) (*action.LintResult, error) {
+	vals, err := h.MergeValues(ValueOptions{})
+	if err != nil {
+		return nil, err
+	}
+	client := action.NewLint()
+	client.Namespace = h.namespace(namespace)
+	client.Strict = strict
+	return client.Run([]string{chartPath}, vals), nil
+}
+
+// Template renders a chart locally, like 'helm template', and returns the manifests and hooks
+func (h *Helm) Template(namespace, chartName, releaseName string) (string, error) {
+	config, err := h.actionConfig(namespace)
+	if err != nil {
+		return "", err
+	}
+	client := action.NewInstall(config)
+	client.DryRun = true
+	client.ClientOnly = true
+	client.Replace = true
+	client.IncludeCRDs = true
+	client.Namespace = h.namespace(namespace)
+	client.ReleaseName = releaseName
+	vals, err := h.MergeValues(ValueOptions{})
+	if err != nil {
+		return "", err
+	}
+	chrt, _, err := h.getLocalChart(chartName, &client.ChartPathOptions)
+	if err != nil {
+		return "", err
+	}
+	rel, err := client.Run(chrt, vals)
+	if err != nil {
+		return "", err
+	}
+	var manifests strings.Builder
+	fmt.Fprintln(&manifests, strings.TrimSpace(rel.Manifest))
+	for _, m := range rel.Hooks {
+		fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", m.Path, m.Manifest)
+	}
+	return manifests.String(), nil
+}
+
+// Package packages a chart directory into a versioned archive under dest,
+// its missing dependencies are resolved first, see BuildDependencies
+func (h *Helm) Package(chartPath, dest string, opts DependencyOptions) (string, error) {
+	if _, err := h.BuildDependencies(chartPath, opts); err != nil {
+		return "", err
+	}
+	client := action.NewPackage()
+	client.Destination = dest
+	client.RepositoryConfig = h.env.RepositoryConfig
+	client.RepositoryCache = h.env.RepositoryCache
+	return client.Run(chartPath, nil)
+}
+
 func (c *Helm) getLocalChart(chartName string, chartPathOptions *action.ChartPathOptions) (*chart.Chart, string, error) {
 	chartPath, err := chartPathOptions.LocateChart(chartName, c.env)
 	if err != nil {
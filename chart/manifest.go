@@ -0,0 +1,409 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a kubernetes object read from a plain manifest
+type Manifest struct {
+	File       string
+	APIVersion string
+	Kind       string
+	Name       string
+	Labels     map[string]string
+	// Node is the mapping node of the object
+	Node *yaml.Node
+}
+
+// liftToken is substituted for the lifted fields while the manifest is encoded
+var liftToken = regexp.MustCompile(`(?m)^(.*?)([^\s:]+): __helm_maker_(\d+)__$`)
+
+// appLabels are the labels an object is grouped into an app by
+var appLabels = []string{"app.kubernetes.io/name", "app", "k8s-app"}
+
+// podSpecPath is the path to the pod spec of the workload kinds
+var podSpecPath = map[string][]string{
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// kindTypes maps object kinds onto the template kinds of the model, so the
+// imported templates are named like the generated ones
+var kindTypes = map[string]string{
+	"Deployment":              "deployment",
+	"Service":                 "svc",
+	"PersistentVolume":        "pv",
+	"PersistentVolumeClaim":   "pvc",
+	"StatefulSet":             "set",
+	"HorizontalPodAutoscaler": "hpa",
+}
+
+// LoadManifests reads the objects of a manifest file, a directory of manifest
+// files or stdin when path is "-". Multi document streams and List objects are
+// split into their objects.
+func LoadManifests(path string) ([]*Manifest, error) {
+	if path == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return ParseManifests("<stdin>", data)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		files = nil
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && isSpecFile(p) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	var objs []*Manifest
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		m, err := ParseManifests(f, data)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, m...)
+	}
+	return objs, nil
+}
+
+// ParseManifests parses a multi document stream of kubernetes objects
+func ParseManifests(file string, data []byte) ([]*Manifest, error) {
+	var objs []*Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		m, err := manifestsOf(file, doc.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, m...)
+	}
+}
+
+func manifestsOf(file string, n *yaml.Node) ([]*Manifest, error) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a kubernetes object", file, n.Line)
+	}
+	m := &Manifest{
+		File:       file,
+		APIVersion: scalarAt(n, "apiVersion"),
+		Kind:       scalarAt(n, "kind"),
+		Name:       scalarAt(n, "metadata", "name"),
+		Labels:     stringMapAt(n, "metadata", "labels"),
+		Node:       n,
+	}
+	if m.APIVersion == "" || m.Kind == "" {
+		return nil, fmt.Errorf("%s:%d: object has no apiVersion or kind", file, n.Line)
+	}
+	if strings.HasSuffix(m.Kind, "List") {
+		var objs []*Manifest
+		if items := nodeAt(n, "items"); items != nil {
+			for _, item := range items.Content {
+				o, err := manifestsOf(file, item)
+				if err != nil {
+					return nil, err
+				}
+				objs = append(objs, o...)
+			}
+		}
+		return objs, nil
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("%s:%d: %s has no metadata.name", file, n.Line, m.Kind)
+	}
+	return []*Manifest{m}, nil
+}
+
+// App returns the name of the app the object belongs to, taken from the app
+// labels of the object, the selector of a Service or the object name.
+func (m *Manifest) App() string {
+	for _, l := range appLabels {
+		if v := m.Labels[l]; v != "" {
+			return toAppName(v)
+		}
+	}
+	if m.Kind == "Service" {
+		selector := stringMapAt(m.Node, "spec", "selector")
+		for _, l := range appLabels {
+			if v := selector[l]; v != "" {
+				return toAppName(v)
+			}
+		}
+	}
+	return toAppName(m.Name)
+}
+
+// ImportManifests groups the objects per app and turns them into the templates of
+// a chart. The image, replicas, env, resources and ports of the first workload of
+// an app and the type and ports of its first Service are lifted into the values of
+// the app, the rest of the objects is kept as it is.
+func ImportManifests(name string, objs []*Manifest) (*Apps, error) {
+	if err := validateChartName(name); err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("no kubernetes objects to import")
+	}
+	apps := &Apps{Name: name, Path: "."}
+	byName := make(map[string]*manifestApp)
+	var order []*manifestApp
+	for _, m := range objs {
+		appName := m.App()
+		a, ok := byName[appName]
+		if !ok {
+			a = &manifestApp{
+				app:   &App{Name: appName, Templates: make(map[string]string)},
+				files: make(map[string][]string),
+				value: make(map[string]interface{}),
+			}
+			byName[appName] = a
+			order = append(order, a)
+		}
+		if err := a.add(m); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range order {
+		for file, docs := range a.files {
+			a.app.Templates[file] = strings.Join(docs, "---\n")
+		}
+		a.app.Values = map[string]interface{}{}
+		if len(a.value) > 0 {
+			a.app.Values["value"] = a.value
+		}
+		apps.Sets = append(apps.Sets, a.app)
+	}
+	return apps, nil
+}
+
+// manifestApp collects the templates and the lifted values of an app
+type manifestApp struct {
+	app      *App
+	files    map[string][]string
+	value    map[string]interface{}
+	workload bool
+	service  bool
+	lifts    []string
+}
+
+func (a *manifestApp) add(m *Manifest) error {
+	cleanManifest(m.Node)
+	a.lifts = a.lifts[:0]
+	if path, ok := podSpecPath[m.Kind]; ok && !a.workload {
+		a.workload = true
+		a.liftWorkload(m, path)
+	}
+	if m.Kind == "Service" && !a.service {
+		a.service = true
+		a.liftService(m)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(m.Node); err != nil {
+		return fmt.Errorf("%s: encoding %s %s: %w", m.File, m.Kind, m.Name, err)
+	}
+	content := escapeTemplate(buf.String())
+	content = liftToken.ReplaceAllStringFunc(content, func(line string) string {
+		g := liftToken.FindStringSubmatch(line)
+		var i int
+		fmt.Sscan(g[3], &i)
+		expr := a.lifts[i]
+		if !strings.HasPrefix(expr, "toYaml ") {
+			return g[1] + g[2] + ": " + expr
+		}
+		indent := len(g[1]) + 2
+		return fmt.Sprintf("%s%s:\n%s{{- %s | nindent %d }}", g[1], g[2], strings.Repeat(" ", indent), expr, indent)
+	})
+
+	kind, ok := kindTypes[m.Kind]
+	if !ok {
+		kind = strings.ToLower(m.Kind)
+	}
+	file := kind + "_%s.yaml"
+	if t, ok := model[kind]; ok && t.FileName != "" {
+		file = t.FileName
+	}
+	file = fmt.Sprintf(file, a.app.Name)
+	a.files[file] = append(a.files[file], content)
+	return nil
+}
+
+// lift replaces the node with a token, which is expanded into expr after encoding
+func (a *manifestApp) lift(n *yaml.Node, expr string) {
+	*n = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprintf("__helm_maker_%d__", len(a.lifts))}
+	a.lifts = append(a.lifts, expr)
+}
+
+func (a *manifestApp) values(path string) string {
+	return fmt.Sprintf(".Values.%s.value.%s", a.app.Name, path)
+}
+
+func (a *manifestApp) liftWorkload(m *Manifest, path []string) {
+	if n := nodeAt(m.Node, "spec", "replicas"); n != nil && n.Kind == yaml.ScalarNode {
+		var replicas int
+		if n.Decode(&replicas) == nil {
+			a.value["replicaCount"] = replicas
+			a.lift(n, "{{ "+a.values("replicaCount")+" }}")
+		}
+	}
+	containers := nodeAt(m.Node, append(path, "containers")...)
+	if containers == nil || containers.Kind != yaml.SequenceNode || len(containers.Content) == 0 {
+		return
+	}
+	c := containers.Content[0]
+	if n := nodeAt(c, "image"); n != nil && !strings.Contains(n.Value, "@") {
+		repository, tag := splitImage(n.Value)
+		if tag == "" {
+			tag = "latest"
+		}
+		image := map[string]interface{}{"repository": repository, "tag": tag}
+		a.value["image"] = image
+		a.lift(n, fmt.Sprintf(`"{{ %s }}:{{ %s }}"`, a.values("image.repository"), a.values("image.tag")))
+		if p := nodeAt(c, "imagePullPolicy"); p != nil {
+			image["pullPolicy"] = p.Value
+			a.lift(p, "{{ "+a.values("image.pullPolicy")+" }}")
+		}
+	}
+	for _, key := range []string{"env", "resources", "ports"} {
+		n := nodeAt(c, key)
+		if n == nil {
+			continue
+		}
+		var v interface{}
+		if n.Decode(&v) != nil {
+			continue
+		}
+		a.value[key] = v
+		a.lift(n, "toYaml "+a.values(key))
+	}
+}
+
+func (a *manifestApp) liftService(m *Manifest) {
+	service := map[string]interface{}{}
+	if n := nodeAt(m.Node, "spec", "type"); n != nil {
+		service["type"] = n.Value
+		a.lift(n, "{{ "+a.values("service.type")+" }}")
+	}
+	if n := nodeAt(m.Node, "spec", "ports"); n != nil {
+		var ports interface{}
+		if n.Decode(&ports) == nil {
+			service["ports"] = ports
+			a.lift(n, "toYaml "+a.values("service.ports"))
+		}
+	}
+	if len(service) > 0 {
+		a.value["service"] = service
+	}
+}
+
+// cleanManifest removes the status and the fields set by the api server from
+// objects exported from a cluster
+func cleanManifest(n *yaml.Node) {
+	deleteKey(n, "status")
+	if meta := nodeAt(n, "metadata"); meta != nil {
+		for _, k := range []string{"creationTimestamp", "resourceVersion", "uid", "selfLink", "generation", "managedFields"} {
+			deleteKey(meta, k)
+		}
+	}
+}
+
+// escapeTemplate keeps template delimiters of the manifest out of the helm templates
+func escapeTemplate(s string) string {
+	s = strings.ReplaceAll(s, "{{", "\x00open\x00")
+	s = strings.ReplaceAll(s, "}}", `{{ "}}" }}`)
+	return strings.ReplaceAll(s, "\x00open\x00", `{{ "{{" }}`)
+}
+
+func nodeAt(n *yaml.Node, path ...string) *yaml.Node {
+	for _, key := range path {
+		if n == nil || n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+				break
+			}
+		}
+		n = next
+	}
+	return n
+}
+
+func scalarAt(n *yaml.Node, path ...string) string {
+	if v := nodeAt(n, path...); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+func stringMapAt(n *yaml.Node, path ...string) map[string]string {
+	m := map[string]string{}
+	if v := nodeAt(n, path...); v != nil && v.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(v.Content); i += 2 {
+			m[v.Content[i].Value] = v.Content[i+1].Value
+		}
+	}
+	return m
+}
+
+func deleteKey(n *yaml.Node, key string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
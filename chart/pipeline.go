@@ -0,0 +1,304 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PipelineMode selects how the deploy nodes of a pipeline become apps
+type PipelineMode int
+
+const (
+	// PipelineAppPerNode creates one app for every deploy node
+	PipelineAppPerNode PipelineMode = iota
+	// PipelineValuesPerEnv creates a single app and a values file per environment
+	PipelineValuesPerEnv
+)
+
+// Pipeline is a CI pipeline document, see test/pipeline.json
+type Pipeline struct {
+	Pipeline struct {
+		ID   string       `json:"id"`
+		Name string       `json:"name"`
+		Data PipelineData `json:"data"`
+	} `json:"pipeline"`
+	Nodes []*PipelineNode `json:"nodes"`
+}
+
+// PipelineData describes the application built by the pipeline
+type PipelineData struct {
+	Application     string      `json:"application"`
+	Commit          string      `json:"commit"`
+	Branch          string      `json:"branch"`
+	Language        string      `json:"language"`
+	LanguageVersion string      `json:"languageVersion"`
+	DefaultPort     json.Number `json:"defaultPort"`
+}
+
+// PipelineNode is a step of the pipeline, deploy nodes carry a service
+type PipelineNode struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Status string      `json:"status"`
+	Data   *DeployData `json:"data"`
+}
+
+// DeployData is the data of a deploy node
+type DeployData struct {
+	Service          string                 `json:"service"`
+	Count            int                    `json:"count"`
+	CPU              json.Number            `json:"cpu"`
+	Memory           json.Number            `json:"memory"`
+	AppEnv           map[string]interface{} `json:"appEnv"`
+	ConfigMap        *PipelineConfigMap     `json:"configMap"`
+	UseConfigMap     bool                   `json:"useConfigMap"`
+	Stage            string                 `json:"stage"`
+	DeployParam      DeployParam            `json:"deployParam"`
+	PrometheusParams map[string]string      `json:"prometheusParams"`
+	CurImg           string                 `json:"curImg"`
+}
+
+// PipelineConfigMap is an existing ConfigMap mounted into the container
+type PipelineConfigMap struct {
+	Name      string `json:"name"`
+	Mode      string `json:"mode"`
+	MountPath string `json:"mount_path"`
+}
+
+// DeployParam are the deploy options of a node
+type DeployParam struct {
+	PackageTimeout              int `json:"packageTimeout"`
+	LivenessInitialDelaySeconds int `json:"livenessInitialDelaySeconds"`
+}
+
+// LoadPipeline reads a pipeline document from a file
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePipeline(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pipeline %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParsePipeline parses a pipeline document, numbers keep their literal text
+func ParsePipeline(data []byte) (*Pipeline, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	p := new(Pipeline)
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DeployNodes returns the nodes which deploy a service
+func (p *Pipeline) DeployNodes() []*PipelineNode {
+	var nodes []*PipelineNode
+	for _, n := range p.Nodes {
+		if n.Data != nil && n.Data.Service != "" {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Apps converts the deploy nodes of the pipeline into the apps of a chart
+func (p *Pipeline) Apps(mode PipelineMode) (*Apps, error) {
+	nodes := p.DeployNodes()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("pipeline %s has no deploy nodes", p.Pipeline.ID)
+	}
+	name := p.Pipeline.Data.Application
+	if name == "" {
+		name = nodes[0].Data.Service
+	}
+	apps := &Apps{Name: name, Path: "."}
+	if err := validateChartName(apps.Name); err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case PipelineAppPerNode:
+		for _, n := range nodes {
+			apps.Sets = append(apps.Sets, &App{
+				Name:   toAppName(n.Data.Service),
+				Types:  []string{"deployment", "svc"},
+				Values: p.values(n),
+			})
+		}
+	case PipelineValuesPerEnv:
+		app := &App{
+			Name:   toAppName(name),
+			Types:  []string{"deployment", "svc"},
+			Values: p.values(nodes[0]),
+		}
+		apps.Sets = []*App{app}
+		apps.Envs = make(map[string]map[string]interface{})
+		for i, n := range nodes {
+			env := n.env()
+			if _, ok := apps.Envs[env]; ok {
+				env = fmt.Sprintf("%s-%d", env, i)
+			}
+			apps.Envs[env] = map[string]interface{}{app.Name: p.values(n)}
+		}
+	default:
+		return nil, fmt.Errorf("unknown pipeline mode %d", mode)
+	}
+	return apps, nil
+}
+
+// env is the environment a node deploys to, like dev or smoke
+func (n *PipelineNode) env() string {
+	if env, ok := n.Data.AppEnv["ENV"].(string); ok && chartName.MatchString(env) {
+		return strings.ToLower(env)
+	}
+	if i := strings.LastIndex(n.Data.Service, "-"); i >= 0 {
+		return n.Data.Service[i+1:]
+	}
+	return n.Data.Service
+}
+
+// image returns the image of the node, nodes which have not been built yet
+// deploy the image built for the other nodes of the pipeline.
+func (p *Pipeline) image(n *PipelineNode) string {
+	if n.Data.CurImg != "" {
+		return n.Data.CurImg
+	}
+	for _, o := range p.DeployNodes() {
+		if o.Data.CurImg != "" {
+			return o.Data.CurImg
+		}
+	}
+	return ""
+}
+
+// values maps a deploy node onto the values read by the deployment and svc templates
+func (p *Pipeline) values(n *PipelineNode) map[string]interface{} {
+	d := n.Data
+	repository, tag := splitImage(p.image(n))
+	port := pipelinePort(d.AppEnv["APP_PORT"], p.Pipeline.Data.DefaultPort)
+
+	value := map[string]interface{}{
+		"replicaCount": d.Count,
+		"image": map[string]interface{}{
+			"repository": repository,
+			"pullPolicy": "IfNotPresent",
+			"tag":        tag,
+		},
+		"imagePullSecrets": []interface{}{},
+		"service": map[string]interface{}{
+			"type": "ClusterIP",
+			"port": port,
+		},
+		"ports": []interface{}{
+			map[string]interface{}{"name": "http", "containerPort": port},
+		},
+		"resources":    map[string]interface{}{},
+		"nodeSelector": map[string]interface{}{},
+		"affinity":     map[string]interface{}{},
+		"env":          pipelineEnv(d.AppEnv),
+		"volumes":      []interface{}{},
+		"volumeMounts": []interface{}{},
+	}
+	limits := map[string]interface{}{}
+	if cpu, err := d.CPU.Float64(); err == nil && cpu > 0 {
+		limits["cpu"] = cpuQuantity(cpu)
+	}
+	if mem, err := d.Memory.Float64(); err == nil && mem > 0 {
+		limits["memory"] = memoryQuantity(mem)
+	}
+	if len(limits) > 0 {
+		value["resources"] = map[string]interface{}{"limits": limits}
+	}
+	if len(d.PrometheusParams) > 0 {
+		annotations := map[string]interface{}{}
+		for k, v := range d.PrometheusParams {
+			annotations[k] = v
+		}
+		value["podAnnotations"] = annotations
+	}
+	if d.DeployParam.LivenessInitialDelaySeconds > 0 {
+		value["livenessProbe"] = map[string]interface{}{
+			"initialDelaySeconds": d.DeployParam.LivenessInitialDelaySeconds,
+		}
+	}
+	if d.UseConfigMap && d.ConfigMap != nil && d.ConfigMap.Name != "" && d.ConfigMap.MountPath != "" {
+		value["configMap"] = map[string]interface{}{
+			"name":      d.ConfigMap.Name,
+			"mountPath": d.ConfigMap.MountPath,
+		}
+	}
+
+	return map[string]interface{}{
+		"appname": d.Service,
+		"version": p.Pipeline.Data.Commit,
+		"value":   value,
+	}
+}
+
+// splitImage splits registry/repo:tag into the repository and the tag
+func splitImage(image string) (string, string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+func pipelinePort(port interface{}, fallback json.Number) int {
+	if port != nil {
+		if p, err := strconv.Atoi(fmt.Sprint(port)); err == nil && p > 0 {
+			return p
+		}
+	}
+	if p, err := fallback.Int64(); err == nil && p > 0 {
+		return int(p)
+	}
+	return 80
+}
+
+// pipelineEnv converts the app env to a sorted container env list
+func pipelineEnv(appEnv map[string]interface{}) []interface{} {
+	names := make([]string, 0, len(appEnv))
+	for k := range appEnv {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	env := make([]interface{}, 0, len(names))
+	for _, k := range names {
+		e := map[string]interface{}{"name": k}
+		switch v := appEnv[k].(type) {
+		case nil:
+		case string:
+			e["value"] = v
+		default:
+			e["value"] = fmt.Sprint(v)
+		}
+		env = append(env, e)
+	}
+	return env
+}
+
+// cpuQuantity formats cores as a kubernetes quantity, 2 -> "2", 0.5 -> "500m"
+func cpuQuantity(cores float64) string {
+	if cores == float64(int64(cores)) {
+		return strconv.FormatInt(int64(cores), 10)
+	}
+	return strconv.FormatInt(int64(cores*1000), 10) + "m"
+}
+
+// memoryQuantity formats GiB as a kubernetes quantity, 4 -> "4Gi", 0.5 -> "512Mi"
+func memoryQuantity(gib float64) string {
+	if gib == float64(int64(gib)) {
+		return strconv.FormatInt(int64(gib), 10) + "Gi"
+	}
+	return strconv.FormatInt(int64(gib*1024), 10) + "Mi"
+}
@@ -0,0 +1,324 @@
+package chart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// ErrConflict is the error of a file which was edited by hand and whose
+// generated content changed since
+var ErrConflict = errors.New("edited by hand and changed by the generator, the edited file is kept")
+
+// generatedMarker finds the header helm-maker puts on the files it generates,
+// with the checksum of the generated content
+var generatedMarker = regexp.MustCompile(`^.*Generated by helm-maker, sha256:([0-9a-f]{64})\b.*$`)
+
+// regionMarker finds the lines which open and close a user region. The lines
+// between them belong to the user and are kept when a file is regenerated.
+var regionMarker = regexp.MustCompile(`helm-maker:(begin|end) ([\w.-]+)`)
+
+// GenerateOpt is an option of Generate
+type GenerateOpt func(o *generateOptions)
+
+type generateOptions struct {
+	force bool
+	// check checks the chart before it is saved, see WithCheck
+	check func(dir string) (*CheckResult, error)
+	// bump bumps the chart version, see WithBump
+	bump       bool
+	prerelease string
+	previous   *chart.Chart
+}
+
+// WithForce overwrites the files edited by hand and the files which were not
+// generated by helm-maker, instead of keeping them
+func WithForce() GenerateOpt {
+	return func(o *generateOptions) {
+		o.force = true
+	}
+}
+
+// WithCheck checks the chart with h.Check before it replaces the chart
+// directory. The problems are in Report.Check, when the check fails nothing is
+// written and its ErrCheckFailed is returned.
+func WithCheck(h *Helm, opts CheckOptions) GenerateOpt {
+	return func(o *generateOptions) {
+		o.check = func(dir string) (*CheckResult, error) {
+			return h.Check(dir, opts)
+		}
+	}
+}
+
+// markerLine is the header of a generated file, files which can't hold a
+// comment have no header and are always regenerated
+func markerLine(name, sum string) string {
+	const msg = "Generated by helm-maker, sha256:%s. Edits outside of helm-maker:begin/end regions are kept only until the generated content changes."
+	switch {
+	case strings.HasPrefix(name, TemplatesDir+"/"):
+		return "{{- /* " + fmt.Sprintf(msg, sum) + " */ -}}\n"
+	case strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") || name == IgnorefileName:
+		return "# " + fmt.Sprintf(msg, sum) + "\n"
+	}
+	return ""
+}
+
+// splitMarker splits a file into the checksum of its header and its content
+func splitMarker(data []byte) (string, []byte, bool) {
+	line := data
+	rest := []byte(nil)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line, rest = data[:i], data[i+1:]
+	}
+	m := generatedMarker.FindSubmatch(line)
+	if m == nil {
+		return "", data, false
+	}
+	return string(m[1]), rest, true
+}
+
+// region is a user region of a file, its lines are between its begin and end lines
+type region struct {
+	name  string
+	begin int
+	end   int
+}
+
+// regions returns the user regions of the lines of a file
+func regions(lines []string) []region {
+	var found []region
+	open := map[string]int{}
+	for i, l := range lines {
+		m := regionMarker.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if m[1] == "begin" {
+			open[m[2]] = i
+		} else if b, ok := open[m[2]]; ok {
+			found = append(found, region{name: m[2], begin: b, end: i})
+			delete(open, m[2])
+		}
+	}
+	return found
+}
+
+// checksum is the checksum of generated content, the user regions are left out
+func checksum(data []byte) string {
+	lines := strings.SplitAfter(string(data), "\n")
+	h := sha256.New()
+	next := 0
+	for _, r := range regions(lines) {
+		for _, l := range lines[next : r.begin+1] {
+			h.Write([]byte(l))
+		}
+		next = r.end
+	}
+	for _, l := range lines[next:] {
+		h.Write([]byte(l))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keepRegions copies the user regions of the old content into the regions of
+// the same name of the generated content
+func keepRegions(generated, old []byte) []byte {
+	oldLines := strings.SplitAfter(string(old), "\n")
+	kept := map[string][]string{}
+	for _, r := range regions(oldLines) {
+		kept[r.name] = oldLines[r.begin+1 : r.end]
+	}
+	if len(kept) == 0 {
+		return generated
+	}
+	lines := strings.SplitAfter(string(generated), "\n")
+	var b strings.Builder
+	next := 0
+	for _, r := range regions(lines) {
+		body, ok := kept[r.name]
+		if !ok {
+			continue
+		}
+		b.WriteString(strings.Join(lines[next:r.begin+1], ""))
+		b.WriteString(strings.Join(body, ""))
+		next = r.end
+	}
+	b.WriteString(strings.Join(lines[next:], ""))
+	return []byte(b.String())
+}
+
+// plannedFile is a file of a chart and what regenerating it does
+type plannedFile struct {
+	name string
+	// data is the content to write, nil when the existing file is kept or removed
+	data []byte
+	// old is the content of the existing file, nil when there is none
+	old []byte
+	// forced is the content WithForce would write instead of keeping the file
+	forced []byte
+	report *FileReport
+}
+
+// planFile decides what regenerating a file does, comparing the generated
+// content with the file of the existing chart directory cdir. A file with a
+// header which still matches its content is regenerated, a file edited since
+// is kept, and when its generated content changed too this is a conflict.
+func planFile(cdir string, f *chart.File, o origin, opts *generateOptions) (*plannedFile, error) {
+	p := &plannedFile{name: f.Name, report: &FileReport{Path: f.Name, App: o.app, Kind: o.kind, Action: FileCreated}}
+	old, err := ioutil.ReadFile(filepath.Join(cdir, filepath.FromSlash(f.Name)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if exists {
+		p.old = old
+	}
+
+	generated := f.Data
+	sum := checksum(generated)
+	header := markerLine(f.Name, sum)
+	if exists {
+		base, content, ok := splitMarker(old)
+		switch {
+		case opts.force:
+		case header == "":
+			// no header, the file is always regenerated
+		case !ok && !bytes.Equal(old, generated):
+			p.report.Action, p.report.Reason = FileSkipped, "not generated by helm-maker"
+			p.forced = append([]byte(header), generated...)
+			return p, nil
+		case ok && checksum(content) != base:
+			p.report.Action = FileConflict
+			if sum == base {
+				p.report.Action, p.report.Reason = FileSkipped, "edited by hand"
+			}
+			p.forced = append([]byte(header), generated...)
+			return p, nil
+		default:
+			generated = keepRegions(generated, content)
+		}
+	}
+	p.data = append([]byte(header), generated...)
+	if exists {
+		p.report.Action = FileOverwritten
+		if bytes.Equal(old, p.data) {
+			p.report.Action, p.report.Reason = FileSkipped, "unchanged"
+		}
+	}
+	return p, nil
+}
+
+// planStale decides what regenerating does with a file of the existing chart
+// directory which is no longer generated, like the template of a kind removed
+// from an app. A generated file which was not edited is removed, any other
+// file is kept.
+func planStale(cdir, name string, opts *generateOptions) (*plannedFile, error) {
+	old, err := ioutil.ReadFile(filepath.Join(cdir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	base, content, ok := splitMarker(old)
+	if !ok {
+		return nil, nil
+	}
+	p := &plannedFile{name: name, old: old, report: &FileReport{Path: name, Action: FileRemoved, Reason: "no longer generated"}}
+	if !opts.force && checksum(content) != base {
+		p.report.Action, p.report.Reason = FileSkipped, "no longer generated, edited by hand"
+	}
+	return p, nil
+}
+
+// chartPlan is what saving a chart into a directory does, file by file
+type chartPlan struct {
+	// dir is the chart directory
+	dir    string
+	exists bool
+	opts   *generateOptions
+	files  []*plannedFile
+	report *Report
+	// conflicts are the files edited by hand whose generated content changed
+	conflicts GenerateErrors
+}
+
+// planChart plans saving the chart into dir/<chart name> without writing anything
+func planChart(c *chart.Chart, dir string, origins map[string]origin, opts []GenerateOpt) (*chartPlan, error) {
+	o := new(generateOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+	plan := &chartPlan{dir: filepath.Join(dir, c.Name()), opts: o}
+	plan.report = &Report{Dir: plan.dir}
+	if o.bump {
+		version, err := bumpChart(c, plan.dir, o)
+		if err != nil {
+			return nil, err
+		}
+		plan.report.Version = version
+	}
+	files, err := ChartFiles(c)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(plan.dir); err == nil {
+		if !fi.IsDir() {
+			return plan, errors.Errorf("file %s already exists and is not a directory", plan.dir)
+		}
+		plan.exists = true
+	}
+
+	var errs GenerateErrors
+	generated := make(map[string]bool, len(files))
+	for _, f := range files {
+		generated[f.Name] = true
+		or := origins[f.Name]
+		p, err := planFile(plan.dir, f, or, o)
+		if err != nil {
+			errs = append(errs, &GenerateError{App: or.app, Kind: or.kind, Path: f.Name, Err: err})
+			continue
+		}
+		plan.files = append(plan.files, p)
+		plan.report.Files = append(plan.report.Files, p.report)
+		if p.report.Action == FileConflict {
+			plan.conflicts = append(plan.conflicts, &GenerateError{App: or.app, Kind: or.kind, Path: f.Name, Err: ErrConflict})
+		}
+	}
+	if len(errs) > 0 {
+		return plan, errs
+	}
+	if !plan.exists {
+		return plan, nil
+	}
+	err = filepath.Walk(plan.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(plan.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if generated[name] {
+			return nil
+		}
+		p, err := planStale(plan.dir, name, o)
+		if err != nil || p == nil {
+			return err
+		}
+		plan.files = append(plan.files, p)
+		plan.report.Files = append(plan.report.Files, p.report)
+		return nil
+	})
+	return plan, err
+}
@@ -0,0 +1,214 @@
+package chart
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/postrender"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+)
+
+// DefaultTimeout is the timeout of the Kubernetes operations of a release
+// when the options set none, like helm
+const DefaultTimeout = 5 * time.Minute
+
+// InstallOptions are the options of Helm.Install, like the flags of helm install
+type InstallOptions struct {
+	// ValueOptions are the values of the release
+	ValueOptions
+	// CreateNamespace creates the namespace of the release when it is missing
+	CreateNamespace bool
+	// Replace reuses the name of a release uninstalled with its history kept
+	Replace bool
+	// Version is the version constraint of a chart of a repository, the
+	// default is the latest version, prereleases included
+	Version string
+	// Timeout of each Kubernetes operation, like waiting for the resources or
+	// a hook, DefaultTimeout when it is 0
+	Timeout time.Duration
+	// Wait waits until the resources of the release are ready
+	Wait bool
+	// WaitForJobs waits until the jobs of the release are complete too
+	WaitForJobs bool
+	// Atomic uninstalls the release when the install fails, it waits
+	Atomic bool
+	// DryRun renders the release against the cluster without installing it
+	DryRun bool
+	// SkipCRDs installs no CRDs of the crds directory of the chart
+	SkipCRDs bool
+	// DisableHooks runs no hooks
+	DisableHooks bool
+	// Description is the description of the release, instead of the one of helm
+	Description string
+	// Labels are added to the labels of every object of the release, over
+	// the labels of the chart. app.kubernetes.io/managed-by is reserved to helm
+	Labels map[string]string
+	// Dependencies are how the dependencies of a chart directory are
+	// resolved when they are missing, see BuildDependencies
+	Dependencies DependencyOptions
+}
+
+// UpgradeOptions are the options of Helm.Upgrade, like the flags of helm upgrade
+type UpgradeOptions struct {
+	// ValueOptions are the values of the release
+	ValueOptions
+	// Version is the version constraint of a chart of a repository, the
+	// default is the latest version, prereleases included
+	Version string
+	// Timeout of each Kubernetes operation, DefaultTimeout when it is 0
+	Timeout time.Duration
+	// Wait waits until the resources of the release are ready
+	Wait bool
+	// WaitForJobs waits until the jobs of the release are complete too
+	WaitForJobs bool
+	// Atomic rolls the release back when the upgrade fails, it waits
+	Atomic bool
+	// DryRun renders the release against the cluster without upgrading it
+	DryRun bool
+	// Force replaces the resources which can't be updated
+	Force bool
+	// Recreate recreates the pods of the release
+	Recreate bool
+	// ResetValues resets the values to the ones of the chart, ReuseValues
+	// merges the values over the ones of the last release
+	ResetValues bool
+	ReuseValues bool
+	// SkipCRDs installs no CRDs of the crds directory of the chart
+	SkipCRDs bool
+	// DisableHooks runs no hooks
+	DisableHooks bool
+	// Description is the description of the release, instead of the one of helm
+	Description string
+	// Labels are added to the labels of every object of the release, over
+	// the labels of the chart. app.kubernetes.io/managed-by is reserved to helm
+	Labels map[string]string
+	// Dependencies are how the dependencies of a chart directory are
+	// resolved when they are missing, see BuildDependencies
+	Dependencies DependencyOptions
+}
+
+// RollbackOptions are the options of Helm.Rollback, like the flags of helm rollback
+type RollbackOptions struct {
+	// Revision is the revision rolled back to, the previous one when it is 0
+	Revision int
+	// Timeout of each Kubernetes operation, DefaultTimeout when it is 0
+	Timeout time.Duration
+	// Wait waits until the resources of the release are ready
+	Wait bool
+	// WaitForJobs waits until the jobs of the release are complete too
+	WaitForJobs bool
+	// DryRun checks the rollback without running it
+	DryRun bool
+	// Force replaces the resources which can't be updated
+	Force bool
+	// Recreate recreates the pods of the release
+	Recreate bool
+	// DisableHooks runs no hooks
+	DisableHooks bool
+}
+
+func (o *InstallOptions) apply(client *action.Install) {
+	client.CreateNamespace = o.CreateNamespace
+	client.Replace = o.Replace
+	client.Version = chartVersion(o.Version)
+	client.Timeout = timeout(o.Timeout)
+	client.Wait = o.Wait
+	client.WaitForJobs = o.WaitForJobs
+	client.Atomic = o.Atomic
+	client.DryRun = o.DryRun
+	client.SkipCRDs = o.SkipCRDs
+	// the CRDs are in the manifest of a dry run
+	client.IncludeCRDs = !o.SkipCRDs
+	client.DisableHooks = o.DisableHooks
+	client.Description = o.Description
+	client.PostRenderer = postRenderer(o.Labels)
+}
+
+func (o *UpgradeOptions) apply(client *action.Upgrade) {
+	client.Version = chartVersion(o.Version)
+	client.Timeout = timeout(o.Timeout)
+	client.Wait = o.Wait
+	client.WaitForJobs = o.WaitForJobs
+	client.Atomic = o.Atomic
+	client.DryRun = o.DryRun
+	client.Force = o.Force
+	client.Recreate = o.Recreate
+	client.ResetValues = o.ResetValues
+	client.ReuseValues = o.ReuseValues
+	client.SkipCRDs = o.SkipCRDs
+	client.DisableHooks = o.DisableHooks
+	client.Description = o.Description
+	client.PostRenderer = postRenderer(o.Labels)
+}
+
+func (o *RollbackOptions) apply(client *action.Rollback) {
+	client.Version = o.Revision
+	client.Timeout = timeout(o.Timeout)
+	client.Wait = o.Wait
+	client.WaitForJobs = o.WaitForJobs
+	client.DryRun = o.DryRun
+	client.Force = o.Force
+	client.Recreate = o.Recreate
+	client.DisableHooks = o.DisableHooks
+}
+
+// chartVersion is the latest version of a chart, prereleases included, unless
+// a version is set
+func chartVersion(version string) string {
+	if version == "" {
+		return ">0.0.0-0"
+	}
+	return version
+}
+
+func timeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultTimeout
+	}
+	return d
+}
+
+// managedByLabel is the label helm sets and checks on the objects of a release
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// checkLabels returns an error when the labels of a release set a reserved label
+func checkLabels(labels map[string]string) error {
+	if _, ok := labels[managedByLabel]; ok {
+		return errors.Errorf("the label %s of the release is reserved to helm", managedByLabel)
+	}
+	return nil
+}
+
+// postRenderer is the post renderer adding the labels, nil without labels
+func postRenderer(labels map[string]string) postrender.PostRenderer {
+	if len(labels) == 0 {
+		return nil
+	}
+	return labelRenderer(labels)
+}
+
+// labelRenderer adds its labels to the labels of every rendered object
+type labelRenderer map[string]string
+
+func (l labelRenderer) Run(rendered *bytes.Buffer) (*bytes.Buffer, error) {
+	nodes, err := (&kio.ByteReader{Reader: rendered, OmitReaderAnnotations: true}).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "adding the labels of the release")
+	}
+	for _, n := range nodes {
+		labels := n.GetLabels()
+		for k, v := range l {
+			labels[k] = v
+		}
+		if err := n.SetLabels(labels); err != nil {
+			return nil, errors.Wrap(err, "adding the labels of the release")
+		}
+	}
+	out := new(bytes.Buffer)
+	if err := (kio.ByteWriter{Writer: out}).Write(nodes); err != nil {
+		return nil, errors.Wrap(err, "adding the labels of the release")
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package chart
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// ValueOptions are the values of a release, like the values flags of helm
+// install. Nested keys are set with the syntax of --set, like
+// web.value.image.tag=1.22 or web.value.env[0].name=LOG_LEVEL.
+type ValueOptions struct {
+	// ValueFiles are merged over the values files of the client, like -f
+	ValueFiles []string
+	// JSONValues are set to JSON values, one key each, like
+	// --set-json 'web.value.resources={"limits":{"cpu":"1"}}'
+	JSONValues []string
+	// Values are set like --set web.value.replicaCount=2,web.value.debug=true
+	Values []string
+	// StringValues are set as strings, like --set-string web.version=1.10
+	StringValues []string
+	// FileValues are set to the content of files, like --set-file web.value.config=app.conf
+	FileValues []string
+	// Map is merged over all the others, its maps are merged key by key
+	Map map[string]interface{}
+}
+
+// MergeValues merges the values files of the client and the values of the
+// options in the order of helm: values files, --set-json, --set, --set-string,
+// --set-file, then the map. A null value deletes the default of the chart
+// when the release is installed, like with helm.
+func (h *Helm) MergeValues(opts ValueOptions) (map[string]interface{}, error) {
+	valueFiles := &values.Options{ValueFiles: append(append([]string{}, h.valueFiles...), opts.ValueFiles...)}
+	base, err := valueFiles.MergeValues(getter.All(h.env))
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range opts.JSONValues {
+		if err := parseJSONValue(value, base); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-json data")
+		}
+	}
+	for _, value := range opts.Values {
+		if err := strvals.ParseInto(value, base); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set data")
+		}
+	}
+	for _, value := range opts.StringValues {
+		if err := strvals.ParseIntoString(value, base); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-string data")
+		}
+	}
+	for _, value := range opts.FileValues {
+		reader := func(rs []rune) (interface{}, error) {
+			data, err := h.fetch(string(rs), strings.Contains(string(rs), "://"))
+			return string(data), err
+		}
+		if err := strvals.ParseIntoFile(value, base, reader); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-file data")
+		}
+	}
+	return mergeMaps(base, opts.Map), nil
+}
+
+// parseJSONValue sets the key of key=<JSON> to the JSON value in dest
+func parseJSONValue(s string, dest map[string]interface{}) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return errors.Errorf("key %q has no value", s)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s[i+1:]), &v); err != nil {
+		return errors.Wrapf(err, "invalid JSON value of %s", s[:i])
+	}
+	// the key is parsed like --set-file, the reader returns the JSON value
+	return strvals.ParseIntoFile(s[:i]+"=-", dest, func([]rune) (interface{}, error) {
+		return v, nil
+	})
+}
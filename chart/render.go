@@ -0,0 +1,177 @@
+package chart
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// RenderOptions are the release and the cluster a chart is rendered for
+type RenderOptions struct {
+	// ReleaseName defaults to release-name, like helm template
+	ReleaseName string
+	// Namespace defaults to the namespace of the kube config
+	Namespace string
+	// ValueFiles are merged over the values files of the client
+	ValueFiles []string
+	// Values are merged over the values files, like --set
+	Values map[string]interface{}
+	// KubeVersion is the target Kubernetes version, like v1.22.0, the
+	// default is the one helm template uses
+	KubeVersion string
+	// APIVersions are added to the API versions of the cluster, like
+	// monitoring.coreos.com/v1/ServiceMonitor
+	APIVersions []string
+	// IsUpgrade renders the chart for an upgrade instead of an install
+	IsUpgrade bool
+}
+
+// Rendered are the rendered templates of a chart
+type Rendered struct {
+	// Manifests are the rendered templates by path, like web/templates/svc_web.yaml.
+	// Helpers and templates which render to nothing are left out.
+	Manifests map[string]string
+	// Notes is the rendered NOTES.txt of the chart
+	Notes string
+}
+
+// Files returns the paths of the manifests in order
+func (r *Rendered) Files() []string {
+	files := make([]string, 0, len(r.Manifests))
+	for f := range r.Manifests {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// String returns all the manifests like helm template prints them
+func (r *Rendered) String() string {
+	var b strings.Builder
+	for _, f := range r.Files() {
+		b.WriteString("---\n# Source: " + f + "\n" + r.Manifests[f])
+		if !strings.HasSuffix(r.Manifests[f], "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// Render renders a chart directory or archive locally with helm's template
+// engine, like helm template. No cluster is needed, the capabilities of the
+// cluster come from the options. A template which does not render to valid
+// YAML is an error.
+func (h *Helm) Render(chartPath string, opts RenderOptions) (*Rendered, error) {
+	c, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	return h.RenderChart(c, opts)
+}
+
+// RenderChart renders a chart in memory, like the chart of BuildChart, see Render
+func (h *Helm) RenderChart(c *chart.Chart, opts RenderOptions) (*Rendered, error) {
+	if err := checkDependencies(c); err != nil {
+		return nil, err
+	}
+	vals, err := h.renderValues(opts)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := capabilities(opts.KubeVersion, opts.APIVersions)
+	if err != nil {
+		return nil, err
+	}
+	if err := chartutil.ProcessDependencies(c, vals); err != nil {
+		return nil, err
+	}
+	name := opts.ReleaseName
+	if name == "" {
+		name = "release-name"
+	}
+	top, err := chartutil.ToRenderValues(c, vals, chartutil.ReleaseOptions{
+		Name:      name,
+		Namespace: h.namespace(opts.Namespace),
+		Revision:  1,
+		IsInstall: !opts.IsUpgrade,
+		IsUpgrade: opts.IsUpgrade,
+	}, caps)
+	if err != nil {
+		return nil, err
+	}
+	files, err := engine.Render(c, top)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Rendered{Manifests: map[string]string{}}
+	for file, content := range files {
+		base := path.Base(file)
+		switch {
+		case base == NotesFileName:
+			// the notes of the sub charts are not shown, like helm does
+			if file == path.Join(c.Name(), TemplatesDir, NotesFileName) {
+				r.Notes = content
+			}
+			continue
+		case strings.HasPrefix(base, "_") || strings.TrimSpace(content) == "":
+			continue
+		}
+		for _, doc := range releaseutil.SplitManifests(content) {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+				return nil, errors.Wrapf(err, "YAML parse error on %s", file)
+			}
+		}
+		r.Manifests[file] = content
+	}
+	return r, nil
+}
+
+// renderValues merges the values files of the client, the values files and the
+// values of the options
+func (h *Helm) renderValues(opts RenderOptions) (map[string]interface{}, error) {
+	return h.MergeValues(ValueOptions{ValueFiles: opts.ValueFiles, Map: opts.Values})
+}
+
+// capabilities are the default capabilities of helm template with the kube
+// version and the extra API versions
+func capabilities(kubeVersion string, apiVersions []string) (*chartutil.Capabilities, error) {
+	caps := chartutil.DefaultCapabilities.Copy()
+	if kubeVersion != "" {
+		kv, err := chartutil.ParseKubeVersion(kubeVersion)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid kube version %q", kubeVersion)
+		}
+		caps.KubeVersion = *kv
+	}
+	// the API versions of the defaults must not change
+	caps.APIVersions = append(append(chartutil.VersionSet{}, caps.APIVersions...), apiVersions...)
+	return caps, nil
+}
+
+// mergeMaps merges b over a, the maps they hold are merged too
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k].(map[string]interface{}); ok {
+				out[k] = mergeMaps(bv, v)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GenerateError is a problem generating a file of a chart
+type GenerateError struct {
+	// App is the app the file belongs to, empty for the files of the chart
+	App string
+	// Kind is the template kind, like deployment
+	Kind string
+	// Path is the file path relative to the chart directory
+	Path string
+	Err  error
+}
+
+func (e *GenerateError) Error() string {
+	var b strings.Builder
+	if e.App != "" {
+		b.WriteString("app " + e.App + ": ")
+	}
+	if e.Kind != "" {
+		b.WriteString("kind " + e.Kind + ": ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *GenerateError) Unwrap() error {
+	return e.Err
+}
+
+// GenerateErrors are the problems found generating all the apps of a chart
+type GenerateErrors []*GenerateError
+
+func (e GenerateErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the errors is target, like errors.Is(err, ErrConflict)
+func (e GenerateErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends the problems of err, an error which is not a GenerateError is
+// a problem of the app and the file path
+func (e GenerateErrors) add(err error, app, path string) GenerateErrors {
+	var errs GenerateErrors
+	var ge *GenerateError
+	switch {
+	case errors.As(err, &errs):
+		return append(e, errs...)
+	case errors.As(err, &ge):
+		return append(e, ge)
+	}
+	return append(e, &GenerateError{App: app, Path: path, Err: err})
+}
+
+// err returns the errors as an error, nil when there are none
+func (e GenerateErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// FileAction is what the generation did with a file of the chart
+type FileAction string
+
+const (
+	// FileCreated is a file which did not exist
+	FileCreated FileAction = "created"
+	// FileOverwritten is a file whose content changed
+	FileOverwritten FileAction = "overwritten"
+	// FileSkipped is a file which was not changed, Reason says why
+	FileSkipped FileAction = "skipped"
+	// FileRemoved is a generated file which is no longer part of the chart
+	FileRemoved FileAction = "removed"
+	// FileConflict is a file edited by hand whose generated content changed
+	// too, the edited file is kept
+	FileConflict FileAction = "conflict"
+)
+
+// FileReport is a file of a generated chart
+type FileReport struct {
+	// Path is the file path relative to the chart directory
+	Path   string
+	App    string
+	Kind   string
+	Action FileAction
+	Reason string
+}
+
+// Report lists the files of a generated chart and what was done with them
+type Report struct {
+	// Dir is the chart directory
+	Dir   string
+	Files []*FileReport
+	// Check are the problems found checking the chart, see WithCheck
+	Check *CheckResult
+	// Version is how the chart version was bumped, see WithBump
+	Version *VersionChange
+}
+
+// Count returns the number of files the action was taken on
+func (r *Report) Count(action FileAction) int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "%-11s %s", f.Action, f.Path)
+		if f.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", f.Reason)
+		}
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "%s: %d created, %d overwritten, %d skipped", r.Dir, r.Count(FileCreated), r.Count(FileOverwritten), r.Count(FileSkipped))
+	if n := r.Count(FileRemoved); n > 0 {
+		fmt.Fprintf(&b, ", %d removed", n)
+	}
+	if n := r.Count(FileConflict); n > 0 {
+		fmt.Fprintf(&b, ", %d conflicts", n)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
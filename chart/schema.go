@@ -0,0 +1,319 @@
+package chart
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/kustomize/kyaml/openapi/kubernetesapi/v1212"
+)
+
+// bundledSchema is the OpenAPI document of a Kubernetes version
+type bundledSchema struct {
+	version string
+	asset   func() ([]byte, error)
+}
+
+// bundledSchemas are the OpenAPI documents of the Kubernetes versions which
+// can be validated offline, by minor version. The other versions are
+// validated with the nearest one.
+var bundledSchemas = map[string]bundledSchema{
+	"1.21": {"v1.21.2", func() ([]byte, error) { return v1212.Asset("kubernetesapi/v1212/swagger.json") }},
+}
+
+// defaultSchemaVersion is the Kubernetes version validated when none is given
+const defaultSchemaVersion = "v1.21.2"
+
+var (
+	schemaMu    sync.Mutex
+	schemaCache = map[string]*openAPI{}
+)
+
+// schema is the part of an OpenAPI v2 schema the manifests are validated with
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schemaOrBool      `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Required             []string           `json:"required"`
+	PreserveUnknown      bool               `json:"x-kubernetes-preserve-unknown-fields"`
+	IntOrString          bool               `json:"x-kubernetes-int-or-string"`
+	GroupVersionKind     []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind"`
+}
+
+// schemaOrBool is an additionalProperties, a schema or true or false
+type schemaOrBool struct {
+	allows bool
+	schema *schema
+}
+
+func (s *schemaOrBool) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.allows); err == nil {
+		return nil
+	}
+	s.allows = true
+	return json.Unmarshal(data, &s.schema)
+}
+
+// openAPI is an OpenAPI v2 document of a Kubernetes version
+type openAPI struct {
+	Definitions map[string]*schema `json:"definitions"`
+	// kinds are the definitions by apiVersion and kind
+	kinds map[string]*schema
+}
+
+func parseOpenAPI(data []byte) (*openAPI, error) {
+	doc := new(openAPI)
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Definitions) == 0 {
+		return nil, errors.New("no definitions")
+	}
+	doc.kinds = map[string]*schema{}
+	for _, s := range doc.Definitions {
+		for _, gvk := range s.GroupVersionKind {
+			apiVersion := gvk.Version
+			if gvk.Group != "" {
+				apiVersion = gvk.Group + "/" + gvk.Version
+			}
+			doc.kinds[apiVersion+"/"+gvk.Kind] = s
+		}
+	}
+	return doc, nil
+}
+
+// loadOpenAPI returns the OpenAPI document of the file, or the bundled one of
+// the Kubernetes version, the version it is for and the version of the
+// bundled document used instead when there is none of the Kubernetes version
+func loadOpenAPI(file, kubeVersion string) (*openAPI, string, string, error) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if file != "" {
+		if doc, ok := schemaCache[file]; ok {
+			return doc, kubeVersion, "", nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, "", "", err
+		}
+		doc, err := parseOpenAPI(data)
+		if err != nil {
+			return nil, "", "", errors.Wrapf(err, "invalid OpenAPI document %s", file)
+		}
+		schemaCache[file] = doc
+		return doc, kubeVersion, "", nil
+	}
+
+	if kubeVersion == "" {
+		kubeVersion = defaultSchemaVersion
+	}
+	kv, err := chartutil.ParseKubeVersion(kubeVersion)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "invalid kube version %q", kubeVersion)
+	}
+	fallback := ""
+	bundled, ok := bundledSchemas[kv.Major+"."+kv.Minor]
+	if !ok {
+		bundled = nearestSchema(kv)
+		fallback = bundled.version
+	}
+	if doc, ok := schemaCache[bundled.version]; ok {
+		return doc, kubeVersion, fallback, nil
+	}
+	data, err := bundled.asset()
+	if err != nil {
+		return nil, "", "", err
+	}
+	doc, err := parseOpenAPI(data)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "invalid bundled OpenAPI document of Kubernetes %s", bundled.version)
+	}
+	schemaCache[bundled.version] = doc
+	return doc, kubeVersion, fallback, nil
+}
+
+// nearestSchema is the bundled schema of the minor version nearest to the
+// Kubernetes version, the newer one of two as near
+func nearestSchema(kv *chartutil.KubeVersion) bundledSchema {
+	minor := func(major, minor string) int {
+		x, _ := strconv.Atoi(major)
+		y, _ := strconv.Atoi(minor)
+		return x*1000 + y
+	}
+	want := minor(kv.Major, kv.Minor)
+	var nearest bundledSchema
+	best, bestMinor := -1, 0
+	for key, b := range bundledSchemas {
+		parts := strings.SplitN(key, ".", 2)
+		m := minor(parts[0], parts[1])
+		d := m - want
+		if d < 0 {
+			d = -d
+		}
+		if best < 0 || d < best || d == best && m > bestMinor {
+			nearest, best, bestMinor = b, d, m
+		}
+	}
+	return nearest
+}
+
+// builtinGroup tells whether an API group is served by Kubernetes itself,
+// the objects of the other groups are custom resources
+func builtinGroup(group string) bool {
+	return !strings.Contains(group, ".") || strings.HasSuffix(group, ".k8s.io")
+}
+
+// validate checks an object against the schema of its kind, problems are
+// reported with their field path. fallback is the version of the bundled
+// schema used for a Kubernetes version without one: the kinds it misses and
+// the fields it does not know may be served by the Kubernetes version, they
+// are warnings.
+func (doc *openAPI) validate(obj map[string]interface{}, kubeVersion, fallback string, report func(sev Severity, field, msg string)) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	if apiVersion == "" || kind == "" {
+		report(SeverityError, "", "apiVersion and kind are required")
+		return
+	}
+	s, ok := doc.kinds[apiVersion+"/"+kind]
+	if !ok {
+		group := ""
+		if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+			group = apiVersion[:i]
+		}
+		switch {
+		case !builtinGroup(group):
+			report(SeverityInfo, "", fmt.Sprintf("no schema of the custom resource %s %s, it is not validated", apiVersion, kind))
+		case fallback != "":
+			report(SeverityWarning, "", fmt.Sprintf("%s %s is not in the schema of Kubernetes %s used for %s, it is not validated", apiVersion, kind, fallback, kubeVersion))
+		default:
+			report(SeverityError, "", fmt.Sprintf("%s %s is not served by Kubernetes %s", apiVersion, kind, kubeVersion))
+		}
+		return
+	}
+	doc.validateValue(obj, s, "", func(field, msg string) {
+		if fallback != "" && msg == "unknown field" {
+			report(SeverityWarning, field, fmt.Sprintf("unknown field in the schema of Kubernetes %s used for %s", fallback, kubeVersion))
+			return
+		}
+		report(SeverityError, field, msg)
+	})
+}
+
+// validateValue checks a value against a schema
+func (doc *openAPI) validateValue(v interface{}, s *schema, field string, report func(field, msg string)) {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		switch name {
+		case "io.k8s.apimachinery.pkg.api.resource.Quantity", "io.k8s.apimachinery.pkg.util.intstr.IntOrString":
+			// a string or a number
+			s = &schema{Type: "string", Format: "int-or-string"}
+		default:
+			ref, ok := doc.Definitions[name]
+			if !ok {
+				return
+			}
+			s = ref
+		}
+	}
+	if v == nil {
+		return
+	}
+	switch {
+	case s.Type == "object" || len(s.Properties) > 0:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			report(field, "expected an object, got "+typeName(v))
+			return
+		}
+		for _, r := range s.Required {
+			if _, ok := m[r]; !ok {
+				report(joinField(field, r), "required field is missing")
+			}
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch p, ok := s.Properties[k]; {
+			case ok:
+				doc.validateValue(m[k], p, joinField(field, k), report)
+			case s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil:
+				doc.validateValue(m[k], s.AdditionalProperties.schema, joinField(field, k), report)
+			case len(s.Properties) > 0 && !s.PreserveUnknown && (s.AdditionalProperties == nil || !s.AdditionalProperties.allows):
+				report(joinField(field, k), "unknown field")
+			}
+		}
+	case s.Type == "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			report(field, "expected an array, got "+typeName(v))
+			return
+		}
+		if s.Items == nil {
+			return
+		}
+		for i, item := range items {
+			doc.validateValue(item, s.Items, fmt.Sprintf("%s[%d]", field, i), report)
+		}
+	case s.Type == "string":
+		if _, ok := v.(string); ok {
+			return
+		}
+		if _, ok := v.(float64); ok && (s.Format == "int-or-string" || s.IntOrString) {
+			return
+		}
+		report(field, "expected a string, got "+typeName(v))
+	case s.Type == "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			report(field, "expected an integer, got "+typeName(v))
+		}
+	case s.Type == "number":
+		if _, ok := v.(float64); !ok {
+			report(field, "expected a number, got "+typeName(v))
+		}
+	case s.Type == "boolean":
+		if _, ok := v.(bool); !ok {
+			report(field, "expected a boolean, got "+typeName(v))
+		}
+	}
+}
+
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func typeName(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	}
+	return fmt.Sprintf("%T", v)
+}
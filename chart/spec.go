@@ -0,0 +1,678 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+// appName is the regular expression an app name has to match. The name is used
+// as a key in values.yaml and is referenced as .Values.<APPNAME> by the templates,
+// so it must be a valid go template field name.
+var appName = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// notAppName matches the characters which can't be used in an app name
+var notAppName = regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+// yamlLine finds the line number in the syntax errors of yaml.v3
+var yamlLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// SpecExtensions are the file extensions read from a spec directory
+var SpecExtensions = []string{".json", ".yaml", ".yml"}
+
+// SpecError is a problem found at a position of a spec file
+type SpecError struct {
+	File   string
+	Line   int
+	Column int
+	// Field is the path of the field, like apps[1].types[0]
+	Field string
+	Msg   string
+}
+
+func (e *SpecError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+		if e.Column > 0 {
+			fmt.Fprintf(&b, ":%d", e.Column)
+		}
+	}
+	if e.Field != "" {
+		b.WriteString(": " + e.Field)
+	}
+	b.WriteString(": " + e.Msg)
+	return b.String()
+}
+
+// SpecErrors are all the problems found in a spec
+type SpecErrors []*SpecError
+
+func (e SpecErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadSpec reads the apps from a spec file, a directory of spec files or
+// stdin when path is "-". The documents of a directory or a multi document
+// stream are merged into one Apps.
+func LoadSpec(path string) (*Apps, error) {
+	if path == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return ParseSpec("<stdin>", data)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseSpec(path, data)
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && isSpecFile(e.Name()) {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no spec files (%s) in directory %s", strings.Join(SpecExtensions, ", "), path)
+	}
+	p := &specParser{apps: new(Apps)}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		p.parse(f, data)
+	}
+	return p.result()
+}
+
+// ParseSpec parses a JSON or YAML spec, name is the file name used in errors
+func ParseSpec(name string, data []byte) (*Apps, error) {
+	p := &specParser{apps: new(Apps)}
+	p.parse(name, data)
+	return p.result()
+}
+
+func isSpecFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range SpecExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// specParser walks the yaml nodes of the spec documents, so every problem can
+// be reported with the line and column it was found at.
+type specParser struct {
+	file string
+	// dir is the directory the files referenced by the spec are read from
+	dir  string
+	apps *Apps
+	// seen maps an app name to the place it was first defined
+	seen map[string]string
+	// envRefs are the apps referenced by envs, checked once all documents are read
+	envRefs []envRef
+	errs    SpecErrors
+}
+
+type envRef struct {
+	app   *yaml.Node
+	field string
+	file  string
+}
+
+func (p *specParser) errorf(n *yaml.Node, field, format string, args ...interface{}) {
+	e := &SpecError{File: p.file, Field: field, Msg: fmt.Sprintf(format, args...)}
+	if n != nil {
+		e.Line, e.Column = n.Line, n.Column
+	}
+	p.errs = append(p.errs, e)
+}
+
+func (p *specParser) result() (*Apps, error) {
+	if len(p.errs) == 0 && p.apps.Name == "" {
+		p.errorf(nil, "name", "chart name is required")
+	}
+	if len(p.errs) == 0 && len(p.apps.Sets) == 0 {
+		p.errorf(nil, "apps", "at least one app is required")
+	}
+	for _, ref := range p.envRefs {
+		if _, ok := p.seen[ref.app.Value]; !ok {
+			p.file = ref.file
+			p.errorf(ref.app, ref.field, "unknown app %q", ref.app.Value)
+		}
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return p.apps, nil
+}
+
+func (p *specParser) parse(file string, data []byte) {
+	p.file = file
+	p.dir = filepath.Dir(file)
+	if file == "<stdin>" {
+		p.dir = "."
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			e := &SpecError{File: file, Msg: err.Error()}
+			if m := yamlLine.FindStringSubmatch(e.Msg); m != nil {
+				e.Line, _ = strconv.Atoi(m[1])
+				e.Msg = strings.TrimPrefix(e.Msg, m[0])
+			}
+			p.errs = append(p.errs, e)
+			return
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		p.parseApps(doc.Content[0])
+	}
+}
+
+func (p *specParser) parseApps(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, "", "expected a mapping with name, version and apps, got %s", kindName(n))
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "name":
+			p.setOnce(val, "name", &p.apps.Name)
+			if p.apps.Name != "" {
+				if err := validateChartName(p.apps.Name); err != nil {
+					p.errorf(val, "name", "%s", err)
+				}
+			}
+		case "version":
+			p.setOnce(val, "version", &p.apps.Version)
+		case "path":
+			p.setOnce(val, "path", &p.apps.Path)
+		case "apps":
+			if val.Kind != yaml.SequenceNode {
+				p.errorf(val, "apps", "expected a list of apps, got %s", kindName(val))
+				continue
+			}
+			for j, a := range val.Content {
+				if app := p.parseApp(a, fmt.Sprintf("apps[%d]", j)); app != nil {
+					p.apps.Sets = append(p.apps.Sets, app)
+				}
+			}
+		case "envs":
+			p.parseEnvs(val)
+		case "baseValues":
+			p.parseBaseValues(val)
+		case "metadata":
+			p.parseMetadata(val)
+		default:
+			p.errorf(key, key.Value, "unknown field")
+		}
+	}
+}
+
+// parseEnvs reads the per environment values, env -> app name -> values
+func (p *specParser) parseEnvs(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, "envs", "expected a mapping of environments, got %s", kindName(n))
+		return
+	}
+	if p.apps.Envs == nil {
+		p.apps.Envs = make(map[string]map[string]interface{})
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		env := key.Value
+		field := "envs." + env
+		if !chartName.MatchString(env) {
+			p.errorf(key, field, "environment name must match the regular expression %q", chartName.String())
+			continue
+		}
+		if val.Kind != yaml.MappingNode {
+			p.errorf(val, field, "expected a mapping of app values, got %s", kindName(val))
+			continue
+		}
+		if !p.checkKeys(val, field) {
+			continue
+		}
+		values := p.apps.Envs[env]
+		if values == nil {
+			values = make(map[string]interface{})
+			p.apps.Envs[env] = values
+		}
+		for j := 0; j+1 < len(val.Content); j += 2 {
+			app, v := val.Content[j], val.Content[j+1]
+			if _, ok := values[app.Value]; ok {
+				p.errorf(app, field+"."+app.Value, "values are already defined by another document")
+				continue
+			}
+			var m map[string]interface{}
+			if err := v.Decode(&m); err != nil {
+				p.errorf(v, field+"."+app.Value, "%s", err)
+				continue
+			}
+			values[app.Value] = m
+			p.envRefs = append(p.envRefs, envRef{app: app, field: field + "." + app.Value, file: p.file})
+		}
+	}
+}
+
+// parseMetadata reads the metadata of the chart, it is validated like helm
+// does when Chart.yaml is built
+func (p *specParser) parseMetadata(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, "metadata", "expected a mapping of chart metadata, got %s", kindName(n))
+		return
+	}
+	if p.apps.Metadata != nil {
+		p.errorf(n, "metadata", "metadata is already defined by another document")
+		return
+	}
+	md := new(ChartMetadata)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		f := "metadata." + key.Value
+		switch key.Value {
+		case "version":
+			p.setOnce(val, f, &p.apps.Version)
+		case "appVersion":
+			md.AppVersion, _ = p.scalar(val, f)
+		case "description":
+			md.Description, _ = p.scalar(val, f)
+		case "kubeVersion":
+			md.KubeVersion, _ = p.scalar(val, f)
+		case "home":
+			md.Home, _ = p.scalar(val, f)
+		case "icon":
+			md.Icon, _ = p.scalar(val, f)
+		case "type":
+			if t, ok := p.scalar(val, f); ok {
+				if t != "application" && t != "library" {
+					p.errorf(val, f, "unknown chart type %q, expected application or library", t)
+					continue
+				}
+				md.Type = t
+			}
+		case "keywords":
+			md.Keywords = p.strings(val, f)
+		case "sources":
+			md.Sources = p.strings(val, f)
+		case "maintainers":
+			if val.Kind != yaml.SequenceNode {
+				p.errorf(val, f, "expected a list of maintainers, got %s", kindName(val))
+				continue
+			}
+			for j, m := range val.Content {
+				if m := p.parseMaintainer(m, fmt.Sprintf("%s[%d]", f, j)); m != nil {
+					md.Maintainers = append(md.Maintainers, m)
+				}
+			}
+		case "annotations":
+			if val.Kind != yaml.MappingNode {
+				p.errorf(val, f, "expected a mapping of strings, got %s", kindName(val))
+				continue
+			}
+			md.Annotations = make(map[string]string)
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				if v, ok := p.scalar(val.Content[j+1], f+"."+val.Content[j].Value); ok {
+					md.Annotations[val.Content[j].Value] = v
+				}
+			}
+		default:
+			p.errorf(key, f, "unknown field")
+		}
+	}
+	p.apps.Metadata = md
+}
+
+func (p *specParser) parseMaintainer(n *yaml.Node, field string) *chart.Maintainer {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, field, "expected a mapping with name, email and url, got %s", kindName(n))
+		return nil
+	}
+	m := new(chart.Maintainer)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		f := field + "." + key.Value
+		switch key.Value {
+		case "name":
+			m.Name, _ = p.scalar(val, f)
+		case "email":
+			m.Email, _ = p.scalar(val, f)
+		case "url":
+			m.URL, _ = p.scalar(val, f)
+		default:
+			p.errorf(key, f, "unknown field")
+		}
+	}
+	if m.Name == "" {
+		p.errorf(n, field+".name", "maintainer name is required")
+		return nil
+	}
+	return m
+}
+
+// strings reads a list of strings
+func (p *specParser) strings(n *yaml.Node, field string) []string {
+	if n.Kind != yaml.SequenceNode {
+		p.errorf(n, field, "expected a list of strings, got %s", kindName(n))
+		return nil
+	}
+	var list []string
+	for i, item := range n.Content {
+		if s, ok := p.scalar(item, fmt.Sprintf("%s[%d]", field, i)); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// parseBaseValues reads the base values file, its path is relative to the spec file
+func (p *specParser) parseBaseValues(n *yaml.Node) {
+	path, ok := p.scalar(n, "baseValues")
+	if !ok {
+		return
+	}
+	if p.apps.BaseValues != nil {
+		p.errorf(n, "baseValues", "base values are already defined by another document")
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.dir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		p.errorf(n, "baseValues", "%s", err)
+		return
+	}
+	if _, err := parseBaseValues(data); err != nil {
+		p.errorf(n, "baseValues", "%s: %s", path, err)
+		return
+	}
+	p.apps.BaseValues = data
+}
+
+// setOnce sets a chart level string, documents that are merged must agree on it
+func (p *specParser) setOnce(n *yaml.Node, field string, dst *string) {
+	v, ok := p.scalar(n, field)
+	if !ok {
+		return
+	}
+	if *dst != "" && *dst != v {
+		p.errorf(n, field, "%q conflicts with %q defined by another document", v, *dst)
+		return
+	}
+	*dst = v
+}
+
+func (p *specParser) scalar(n *yaml.Node, field string) (string, bool) {
+	if n.Kind != yaml.ScalarNode || n.Tag == "!!null" {
+		p.errorf(n, field, "expected a string, got %s", kindName(n))
+		return "", false
+	}
+	return n.Value, true
+}
+
+func (p *specParser) parseApp(n *yaml.Node, field string) *App {
+	if n.Kind != yaml.MappingNode {
+		p.errorf(n, field, "expected a mapping with name, types and values, got %s", kindName(n))
+		return nil
+	}
+	app := new(App)
+	var nameNode *yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		f := field + "." + key.Value
+		switch key.Value {
+		case "name":
+			app.Name, _ = p.scalar(val, f)
+			nameNode = val
+		case "types":
+			if val.Kind != yaml.SequenceNode {
+				p.errorf(val, f, "expected a list of template kinds, got %s", kindName(val))
+				continue
+			}
+			for j, t := range val.Content {
+				tf := fmt.Sprintf("%s[%d]", f, j)
+				kind, ok := p.scalar(t, tf)
+				if !ok {
+					continue
+				}
+				if _, known := model[kind]; !known {
+					p.errorf(t, tf, "unknown template kind %q, expected one of %s", kind, strings.Join(model.Kinds(), ", "))
+					continue
+				}
+				app.Types = append(app.Types, kind)
+			}
+		case "templates":
+			if val.Kind != yaml.MappingNode {
+				p.errorf(val, f, "expected a mapping of file names to templates, got %s", kindName(val))
+				continue
+			}
+			app.Templates = make(map[string]string)
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				name, content := val.Content[j], val.Content[j+1]
+				tf := f + "." + name.Value
+				if name.Value != filepath.Base(name.Value) || filepath.Ext(name.Value) == "" {
+					p.errorf(name, tf, "template file name must be a file name with an extension")
+					continue
+				}
+				if t, ok := p.scalar(content, tf); ok {
+					app.Templates[name.Value] = t
+				}
+			}
+		case "values":
+			if val.Tag == "!!null" {
+				continue
+			}
+			if val.Kind != yaml.MappingNode {
+				p.errorf(val, f, "expected a mapping, got %s", kindName(val))
+				continue
+			}
+			if !p.checkKeys(val, f) {
+				continue
+			}
+			if err := val.Decode(&app.Values); err != nil {
+				p.errorf(val, f, "%s", err)
+				continue
+			}
+			app.valuesNode = val
+			for _, key := range []string{"configMap", "secret"} {
+				p.readFiles(app.Values, val, key, f)
+			}
+		case "schema":
+			if val.Kind != yaml.MappingNode {
+				p.errorf(val, f, "expected a mapping of value paths to JSON schema keywords, got %s", kindName(val))
+				continue
+			}
+			app.Schema = make(map[string]map[string]interface{})
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				path, keywords := val.Content[j], val.Content[j+1]
+				tf := f + "." + path.Value
+				if keywords.Kind != yaml.MappingNode {
+					p.errorf(keywords, tf, "expected a mapping of JSON schema keywords, got %s", kindName(keywords))
+					continue
+				}
+				var m map[string]interface{}
+				if err := keywords.Decode(&m); err != nil {
+					p.errorf(keywords, tf, "%s", err)
+					continue
+				}
+				app.Schema[path.Value] = m
+			}
+		default:
+			p.errorf(key, f, "unknown field")
+		}
+	}
+
+	switch {
+	case nameNode == nil:
+		p.errorf(n, field+".name", "app name is required")
+		return nil
+	case app.Name == "":
+		return nil
+	case !appName.MatchString(app.Name):
+		p.errorf(nameNode, field+".name", "app name %q must match the regular expression %q", app.Name, appName.String())
+		return nil
+	}
+	pos := fmt.Sprintf("%s:%d", p.file, nameNode.Line)
+	if p.seen == nil {
+		p.seen = make(map[string]string)
+	}
+	if prev, ok := p.seen[app.Name]; ok {
+		p.errorf(nameNode, field+".name", "app %q is already defined at %s", app.Name, prev)
+		return nil
+	}
+	p.seen[app.Name] = pos
+	return app
+}
+
+// readFiles moves the files listed by value.<key>.files into its data, the
+// paths are relative to the spec file. Files which are not UTF-8 go into
+// binaryData encoded in base64.
+func (p *specParser) readFiles(values map[string]interface{}, n *yaml.Node, key, field string) {
+	files := nodeAt(n, "value", key, "files")
+	if files == nil {
+		return
+	}
+	field += ".value." + key + ".files"
+	if files.Kind != yaml.SequenceNode {
+		p.errorf(files, field, "expected a list of file paths, got %s", kindName(files))
+		return
+	}
+	value, _ := values["value"].(map[string]interface{})
+	obj, ok := value[key].(map[string]interface{})
+	if !ok {
+		return
+	}
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		data = make(map[string]interface{})
+	}
+	binaryData, ok := obj["binaryData"].(map[string]interface{})
+	if !ok {
+		binaryData = make(map[string]interface{})
+	}
+	for i, f := range files.Content {
+		ff := fmt.Sprintf("%s[%d]", field, i)
+		path, ok := p.scalar(f, ff)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(p.dir, path)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			p.errorf(f, ff, "%s", err)
+			continue
+		}
+		name := filepath.Base(path)
+		if _, ok := data[name]; ok {
+			p.errorf(f, ff, "key %q is already defined", name)
+			continue
+		}
+		if _, ok := binaryData[name]; ok {
+			p.errorf(f, ff, "key %q is already defined", name)
+			continue
+		}
+		if utf8.Valid(b) {
+			data[name] = string(b)
+		} else {
+			binaryData[name] = base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	delete(obj, "files")
+	if len(data) > 0 {
+		obj["data"] = data
+	}
+	if len(binaryData) > 0 {
+		obj["binaryData"] = binaryData
+	}
+}
+
+// checkKeys reports mapping keys which are not strings, values.yaml can't hold them
+func (p *specParser) checkKeys(n *yaml.Node, field string) bool {
+	ok := true
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if key.Kind != yaml.ScalarNode || (key.Tag != "!!str" && key.Tag != "!!merge") {
+				p.errorf(key, field, "key %q must be a string", key.Value)
+				ok = false
+				continue
+			}
+			ok = p.checkKeys(n.Content[i+1], field+"."+key.Value) && ok
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			ok = p.checkKeys(c, fmt.Sprintf("%s[%d]", field, i)) && ok
+		}
+	}
+	return ok
+}
+
+// toAppName turns a name like proxy-20220510-dev into the app name proxy_20220510_dev
+func toAppName(name string) string {
+	app := notAppName.ReplaceAllString(name, "_")
+	if app == "" || (app[0] >= '0' && app[0] <= '9') {
+		app = "_" + app
+	}
+	return app
+}
+
+func kindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	if n.Tag == "!!null" {
+		return "null"
+	}
+	return strings.TrimPrefix(n.Tag, "!!") + " " + strconv.Quote(n.Value)
+}
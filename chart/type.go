@@ -31,18 +31,25 @@ const defaultValues = `
 # This is a YAML-formatted file.
 # Declare variables to be passed into your templates.
 
+# Number of pods, unless autoscaling is enabled.
 replicaCount: 1
 
+# Image of the container.
 image:
   repository: nginx
   pullPolicy: IfNotPresent
   # Overrides the image tag whose default is the chart appVersion.
   tag: ""
 
+# Secrets of the registries the image is pulled from.
 imagePullSecrets: []
 nameOverride: ""
+# Overrides the name of the objects of the app, its appname by default.
 fullnameOverride: ""
+# Annotations of the pods.
 podAnnotations: {}
+
+# Security context of the pods and of the container.
 podSecurityContext: {}
   # fsGroup: 2000
 
@@ -54,21 +61,25 @@ securityContext: {}
   # runAsNonRoot: true
   # runAsUser: 1000
 
+# Service of the app, the ports of a Service with several ports are given by
+# ports.
 service:
   type: ClusterIP
   port: 80
 
+# Ingress of the app, to its Service.
 ingress:
   enabled: false
   className: ""
   annotations: {}
-	# kubernetes.io/ingress.class: nginx
-	# kubernetes.io/tls-acme: "true"
+    # kubernetes.io/ingress.class: nginx
+    # kubernetes.io/tls-acme: "true"
+
   hosts:
-	- host: chart-example.local
-	  paths:
-		- path: /
-		  pathType: ImplementationSpecific
+    - host: chart-example.local
+      paths:
+        - path: /
+          pathType: ImplementationSpecific
   tls: []
   #  - secretName: chart-example-tls
   #    hosts:
@@ -86,6 +97,7 @@ resources: {}
   #   cpu: 100m
   #   memory: 128Mi
 
+# HorizontalPodAutoscaler of the app.
 autoscaling:
   enabled: false
   minReplicas: 1
@@ -93,11 +105,96 @@ autoscaling:
   targetCPUUtilizationPercentage: 80
   # targetMemoryUtilizationPercentage: 80
 
+# Scheduling of the pods.
 nodeSelector: {}
 
 tolerations: []
 
 affinity: {}
+
+# Labels added to every object of the app.
+labels: {}
+
+# Container port of an app without ports, the port of its Service.
+containerPort: 80
+
+# Ports of the container, each one is also a port of the Service unless expose
+# is false.
+ports: []
+  # - name: http
+  #   containerPort: 8080
+  #   protocol: TCP
+  #   servicePort: 80
+  #   expose: true
+
+# Probes of the container, an app with ports gets httpGet probes on / of its
+# first port. type is httpGet, tcpSocket, exec or grpc, enabled: false turns a
+# probe off.
+livenessProbe: {}
+  # type: httpGet
+  # path: /healthz
+  # initialDelaySeconds: 10
+
+readinessProbe: {}
+startupProbe: {}
+
+# Command and arguments of the container, the ones of the image when empty.
+command: []
+args: []
+
+# Environment variables of the container.
+env: []
+  # - name: LOG_LEVEL
+  #   value: info
+
+# ConfigMap of the app, its data is mounted at mountPath or given to the
+# container as environment variables with envFrom. files are read into data.
+configMap: {}
+  # files: [config/app.conf]
+  # mountPath: /etc/app
+  # envFrom: false
+
+# Secret of the app, set like configMap.
+secret: {}
+
+# Volumes of the pods and their mounts in the container.
+volumes: []
+volumeMounts: []
+
+# Storage of the app, a PersistentVolumeClaim mounted at mountPath, or a
+# volumeClaimTemplate of a StatefulSet.
+persistence: {}
+  # enabled: true
+  # size: 1Gi
+  # storageClass: ""
+  # accessModes: [ReadWriteOnce]
+  # mountPath: /data
+  # existingClaim: ""
+
+# ServiceAccount the pods run as.
+serviceAccount: {}
+  # create: true
+  # annotations: {}
+  # name: ""
+
+# Settings of the Job and of the CronJob of the app.
+job: {}
+cronjob: {}
+  # schedule: "0 * * * *"
+`
+
+// defaultAppValues documents the values of an app, the ones of its templates
+// are documented by defaultValues
+const defaultAppValues = `
+# Name of the app, the name of its objects unless value.fullnameOverride is set.
+appname: ""
+
+# Version of the app, the app.kubernetes.io/version label of its objects, the
+# appVersion of the chart when empty.
+version: ""
+
+# Values of the templates of the app.
+value: {}
 `
 
 const defaultIgnore = `# Patterns to ignore when building packages.
@@ -125,37 +222,39 @@ const defaultIgnore = `# Patterns to ignore when building packages.
 .vscode/
 `
 
-const defaultIngress = `{{- if .Values.ingress.enabled -}}
-{{- $fullName := include "<CHARTNAME>.fullname" . -}}
-{{- $svcPort := .Values.service.port -}}
-{{- if and .Values.ingress.className (not (semverCompare ">=1.18-0" .Capabilities.KubeVersion.GitVersion)) }}
-  {{- if not (hasKey .Values.ingress.annotations "kubernetes.io/ingress.class") }}
-  {{- $_ := set .Values.ingress.annotations "kubernetes.io/ingress.class" .Values.ingress.className}}
+const defaultIngress = `{{- $ingress := .Values.<APPNAME>.value.ingress | default dict }}
+{{- if $ingress.enabled -}}
+{{- $fullName := include "<CHARTNAME>.<APPNAME>.fullname" . -}}
+{{- $svcPort := include "<CHARTNAME>.<APPNAME>.servicePort" . -}}
+{{- $annotations := $ingress.annotations | default dict -}}
+{{- if and $ingress.className (not (semverCompare ">=1.18-0" .Capabilities.KubeVersion.GitVersion)) }}
+  {{- if not (hasKey $annotations "kubernetes.io/ingress.class") }}
+  {{- $_ := set $annotations "kubernetes.io/ingress.class" $ingress.className }}
   {{- end }}
 {{- end }}
-{{- if semverCompare ">=1.19-0" .Capabilities.KubeVersion.GitVersion -}}
+{{- if semverCompare ">=1.19-0" .Capabilities.KubeVersion.GitVersion }}
 apiVersion: networking.k8s.io/v1
-{{- else if semverCompare ">=1.14-0" .Capabilities.KubeVersion.GitVersion -}}
+{{- else if semverCompare ">=1.14-0" .Capabilities.KubeVersion.GitVersion }}
 apiVersion: networking.k8s.io/v1beta1
-{{- else -}}
+{{- else }}
 apiVersion: extensions/v1beta1
 {{- end }}
 kind: Ingress
 metadata:
   name: {{ $fullName }}
   labels:
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
-  {{- with .Values.ingress.annotations }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+  {{- with $annotations }}
   annotations:
     {{- toYaml . | nindent 4 }}
   {{- end }}
 spec:
-  {{- if and .Values.ingress.className (semverCompare ">=1.18-0" .Capabilities.KubeVersion.GitVersion) }}
-  ingressClassName: {{ .Values.ingress.className }}
+  {{- if and $ingress.className (semverCompare ">=1.18-0" .Capabilities.KubeVersion.GitVersion) }}
+  ingressClassName: {{ $ingress.className }}
   {{- end }}
-  {{- if .Values.ingress.tls }}
+  {{- if $ingress.tls }}
   tls:
-    {{- range .Values.ingress.tls }}
+    {{- range $ingress.tls }}
     - hosts:
         {{- range .hosts }}
         - {{ . | quote }}
@@ -164,14 +263,14 @@ spec:
     {{- end }}
   {{- end }}
   rules:
-    {{- range .Values.ingress.hosts }}
+    {{- range $ingress.hosts }}
     - host: {{ .host | quote }}
       http:
         paths:
           {{- range .paths }}
           - path: {{ .path }}
-            {{- if and .pathType (semverCompare ">=1.18-0" $.Capabilities.KubeVersion.GitVersion) }}
-            pathType: {{ .pathType }}
+            {{- if semverCompare ">=1.18-0" $.Capabilities.KubeVersion.GitVersion }}
+            pathType: {{ .pathType | default "ImplementationSpecific" }}
             {{- end }}
             backend:
               {{- if semverCompare ">=1.19-0" $.Capabilities.KubeVersion.GitVersion }}
@@ -191,145 +290,325 @@ const defaultDeployment = `
 apiVersion: apps/v1
 kind: Deployment
 metadata:
-  name: {{ .Values.<APPNAME>.appname }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+spec:
+  {{- if not (.Values.<APPNAME>.value.autoscaling | default dict).enabled }}
+  replicas: {{ .Values.<APPNAME>.value.replicaCount }}
+  {{- end }}
+  selector:
+    matchLabels:
+      {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 6 }}
+  template:
+    {{- include "<CHARTNAME>.<APPNAME>.podTemplate" (merge (dict "workload" "deployment") .) | nindent 4 }}
+`
+
+const defaultStatefulSet = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}-headless
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  ports:
+    {{- range include "<CHARTNAME>.<APPNAME>.ports" . | fromYamlArray }}
+    - port: {{ .containerPort }}
+      targetPort: {{ .name }}
+      protocol: {{ .protocol }}
+      name: {{ .name }}
+    {{- end }}
+  selector:
+    {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 4 }}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
   labels:
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
 spec:
-  replicas: {{ .Values.<APPNAME>.replicaCount }}
+  serviceName: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}-headless
+  {{- if not (.Values.<APPNAME>.value.autoscaling | default dict).enabled }}
+  replicas: {{ .Values.<APPNAME>.value.replicaCount }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.podManagementPolicy }}
+  podManagementPolicy: {{ . }}
+  {{- end }}
   selector:
     matchLabels:
-      {{- include "<CHARTNAME>.selectorLabels" . | nindent 6 }}
+      {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 6 }}
   template:
+    {{- include "<CHARTNAME>.<APPNAME>.podTemplate" (merge (dict "workload" "set") .) | nindent 4 }}
+  {{- with .Values.<APPNAME>.value.persistence }}
+  {{- if .enabled }}
+  volumeClaimTemplates:
+    - metadata:
+        name: data
+      spec:
+        accessModes:
+          {{- toYaml (.accessModes | default (list "ReadWriteOnce")) | nindent 10 }}
+        {{- if .storageClass }}
+        storageClassName: {{ if eq "-" .storageClass }}""{{ else }}{{ .storageClass }}{{ end }}
+        {{- end }}
+        resources:
+          requests:
+            storage: {{ .size | default "1Gi" }}
+  {{- end }}
+  {{- end }}
+`
+
+const defaultJob = `{{- $job := .Values.<APPNAME>.value.job | default dict }}
+apiVersion: batch/v1
+kind: Job
+metadata:
+  {{- if $job.hook }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
+  {{- else }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}-{{ .Release.Revision }}
+  {{- end }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+  {{- if $job.hook }}
+  annotations:
+    "helm.sh/hook": {{ $job.hook | quote }}
+    "helm.sh/hook-weight": {{ $job.hookWeight | default 0 | quote }}
+    "helm.sh/hook-delete-policy": {{ $job.hookDeletePolicy | default "before-hook-creation" | quote }}
+  {{- end }}
+spec:
+  {{- include "<CHARTNAME>.<APPNAME>.jobSpec" (merge (dict "workload" "job") .) | trim | nindent 2 }}
+`
+
+const defaultCronJob = `{{- $cronjob := .Values.<APPNAME>.value.cronjob | default dict }}
+{{- if .Capabilities.APIVersions.Has "batch/v1/CronJob" }}
+apiVersion: batch/v1
+{{- else }}
+apiVersion: batch/v1beta1
+{{- end }}
+kind: CronJob
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+spec:
+  schedule: {{ required "<APPNAME>.value.cronjob.schedule is required" $cronjob.schedule | quote }}
+  concurrencyPolicy: {{ $cronjob.concurrencyPolicy | default "Forbid" }}
+  {{- if hasKey $cronjob "suspend" }}
+  suspend: {{ $cronjob.suspend }}
+  {{- end }}
+  {{- with $cronjob.startingDeadlineSeconds }}
+  startingDeadlineSeconds: {{ . }}
+  {{- end }}
+  {{- if hasKey $cronjob "successfulJobsHistoryLimit" }}
+  successfulJobsHistoryLimit: {{ $cronjob.successfulJobsHistoryLimit }}
+  {{- end }}
+  {{- if hasKey $cronjob "failedJobsHistoryLimit" }}
+  failedJobsHistoryLimit: {{ $cronjob.failedJobsHistoryLimit }}
+  {{- end }}
+  jobTemplate:
     metadata:
-      {{- with .Values.<APPNAME>.podAnnotations }}
-      annotations:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
       labels:
-        {{- include "<CHARTNAME>.selectorLabels" . | nindent 8 }}
+        {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 8 }}
     spec:
-      {{- with .Values.<APPNAME>.imagePullSecrets }}
-      imagePullSecrets:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-      containers:
-        - name: {{ .Values.<APPNAME>.appname }}
-          image: "{{ .Values.<APPNAME>.value.image.repository }}:{{ .Values.<APPNAME>.value.image.tag | default .Chart.AppVersion }}"
-          imagePullPolicy: {{ .Values.<APPNAME>.value.image.pullPolicy }}
-          ports:
-          - containerPort: 80
-            name: http
-            protocol: TCP
-          {{- with .Values.<APPNAME>.value.env }}
-          env:
-            {{- toYaml . | nindent 12 }}
-          {{- end }}
-          livenessProbe:
-            httpGet:
-              path: /
-              port: http
-          readinessProbe:
-            httpGet:
-              path: /
-              port: http
-          resources:
-            {{- toYaml .Values.<APPNAME>.value.resources | nindent 12 }}
-      {{- with .Values.<APPNAME>.value.nodeSelector }}
-      nodeSelector:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-      {{- with .Values.<APPNAME>.value.affinity }}
-      affinity:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
-      {{- with .Values.<APPNAME>.value.tolerations }}
-      tolerations:
-        {{- toYaml . | nindent 8 }}
-      {{- end }}
+      {{- include "<CHARTNAME>.<APPNAME>.jobSpec" (merge (dict "workload" "cronjob") .) | trim | nindent 6 }}
+`
+
+const defaultDaemonSet = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+spec:
+  {{- with .Values.<APPNAME>.value.updateStrategy }}
+  updateStrategy:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  selector:
+    matchLabels:
+      {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 6 }}
+  template:
+    {{- include "<CHARTNAME>.<APPNAME>.podTemplate" (merge (dict "workload" "daemonset") .) | nindent 4 }}
+`
+
+const defaultPersistentVolumeClaim = `{{- with .Values.<APPNAME>.value.persistence }}
+{{- if and .enabled (not .existingClaim) }}
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }}-data
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" $ | nindent 4 }}
+spec:
+  accessModes:
+    {{- toYaml (.accessModes | default (list "ReadWriteOnce")) | nindent 4 }}
+  {{- if .volume }}
+  volumeName: {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }}-pv
+  storageClassName: {{ if and .storageClass (ne "-" .storageClass) }}{{ .storageClass }}{{ else }}""{{ end }}
+  {{- else if .storageClass }}
+  storageClassName: {{ if eq "-" .storageClass }}""{{ else }}{{ .storageClass }}{{ end }}
+  {{- end }}
+  resources:
+    requests:
+      storage: {{ .size | default "1Gi" }}
+{{- end }}
+{{- end }}
 `
 
-const defaultService = `apiVersion: v1
+const defaultPersistentVolume = `{{- with .Values.<APPNAME>.value.persistence }}
+{{- if and .enabled .volume }}
+apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }}-pv
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" $ | nindent 4 }}
+spec:
+  capacity:
+    storage: {{ .size | default "1Gi" }}
+  accessModes:
+    {{- toYaml (.accessModes | default (list "ReadWriteOnce")) | nindent 4 }}
+  persistentVolumeReclaimPolicy: {{ .reclaimPolicy | default "Retain" }}
+  storageClassName: {{ if and .storageClass (ne "-" .storageClass) }}{{ .storageClass }}{{ else }}""{{ end }}
+  {{- toYaml .volume | nindent 2 }}
+{{- end }}
+{{- end }}
+`
+
+const defaultConfigMap = `{{- $config := .Values.<APPNAME>.value.configMap | default dict }}
+{{- if or $config.data $config.binaryData }}
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.configMapName" . }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+{{- with $config.data }}
+data:
+  {{- range $key, $value := . }}
+  {{ $key }}: {{ $value | toString | quote }}
+  {{- end }}
+{{- end }}
+{{- with $config.binaryData }}
+binaryData:
+  {{- toYaml . | nindent 2 }}
+{{- end }}
+{{- end }}
+`
+
+const defaultSecret = `{{- $secret := .Values.<APPNAME>.value.secret | default dict }}
+{{- if or $secret.data $secret.binaryData }}
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ include "<CHARTNAME>.<APPNAME>.secretName" . }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+type: {{ $secret.type | default "Opaque" }}
+data:
+  {{- range $key, $value := $secret.data }}
+  {{ $key }}: {{ $value | toString | b64enc | quote }}
+  {{- end }}
+  {{- range $key, $value := $secret.binaryData }}
+  {{ $key }}: {{ $value | quote }}
+  {{- end }}
+{{- end }}
+`
+
+const defaultService = `{{- $ports := list }}
+{{- range include "<CHARTNAME>.<APPNAME>.ports" . | fromYamlArray }}
+{{- if .expose }}
+{{- $ports = append $ports . }}
+{{- end }}
+{{- end }}
+{{- /* a Service without ports is rejected, there is none when no port is exposed */}}
+{{- if $ports -}}
+apiVersion: v1
 kind: Service
 metadata:
-  name: {{ .Values.<APPNAME>.appname }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
   labels:
-    {{ .Values.<APPNAME>.labels }}
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
 spec:
-  type: {{ .Values.<APPNAME>.value.service.type }}
+  {{- $type := (.Values.<APPNAME>.value.service | default dict).type | default "ClusterIP" }}
+  type: {{ $type }}
   ports:
-    - port: {{ .Values.<APPNAME>.value.service.port }}
-      targetPort: http
-      protocol: TCP
-      name: http
+    {{- range $ports }}
+    - port: {{ .servicePort }}
+      targetPort: {{ .name }}
+      protocol: {{ .protocol }}
+      name: {{ .name }}
+      {{- with .appProtocol }}
+      appProtocol: {{ . }}
+      {{- end }}
+      {{- if and .nodePort (has $type (list "NodePort" "LoadBalancer")) }}
+      nodePort: {{ .nodePort }}
+      {{- end }}
+    {{- end }}
+  selector:
+    {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 4 }}
+{{- end }}
 `
 
-const defaultServiceAccount = `{{- if .Values.serviceAccount.create -}}
+const defaultServiceAccount = `{{- $serviceAccount := .Values.<APPNAME>.value.serviceAccount | default dict }}
+{{- if $serviceAccount.create -}}
 apiVersion: v1
 kind: ServiceAccount
 metadata:
-  name: {{ include "<CHARTNAME>.serviceAccountName" . }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.serviceAccountName" . }}
   labels:
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
-  {{- with .Values.serviceAccount.annotations }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
+  {{- with $serviceAccount.annotations }}
   annotations:
     {{- toYaml . | nindent 4 }}
   {{- end }}
 {{- end }}
 `
 
-const defaultHorizontalPodAutoscaler = `{{- if .Values.autoscaling.enabled }}
-apiVersion: autoscaling/v2beta1
+const defaultHorizontalPodAutoscaler = `{{- $autoscaling := .Values.<APPNAME>.value.autoscaling | default dict }}
+{{- if $autoscaling.enabled }}
+{{- if semverCompare ">=1.23-0" .Capabilities.KubeVersion.GitVersion }}
+apiVersion: autoscaling/v2
+{{- else }}
+apiVersion: autoscaling/v2beta2
+{{- end }}
 kind: HorizontalPodAutoscaler
 metadata:
-  name: {{ include "<CHARTNAME>.fullname" . }}
+  name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
   labels:
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
 spec:
   scaleTargetRef:
     apiVersion: apps/v1
-    kind: Deployment
-    name: {{ include "<CHARTNAME>.fullname" . }}
-  minReplicas: {{ .Values.autoscaling.minReplicas }}
-  maxReplicas: {{ .Values.autoscaling.maxReplicas }}
+    kind: {{ $autoscaling.kind | default "Deployment" }}
+    name: {{ include "<CHARTNAME>.<APPNAME>.fullname" . }}
+  minReplicas: {{ $autoscaling.minReplicas | default 1 }}
+  maxReplicas: {{ required "autoscaling.maxReplicas of <APPNAME> is required" $autoscaling.maxReplicas }}
   metrics:
-    {{- if .Values.autoscaling.targetCPUUtilizationPercentage }}
+    {{- with $autoscaling.targetCPUUtilizationPercentage }}
     - type: Resource
       resource:
         name: cpu
-        targetAverageUtilization: {{ .Values.autoscaling.targetCPUUtilizationPercentage }}
+        target:
+          type: Utilization
+          averageUtilization: {{ . }}
     {{- end }}
-    {{- if .Values.autoscaling.targetMemoryUtilizationPercentage }}
+    {{- with $autoscaling.targetMemoryUtilizationPercentage }}
     - type: Resource
       resource:
         name: memory
-        targetAverageUtilization: {{ .Values.autoscaling.targetMemoryUtilizationPercentage }}
+        target:
+          type: Utilization
+          averageUtilization: {{ . }}
     {{- end }}
 {{- end }}
 `
 
-const defaultNotes = `1. Get the application URL by running these commands:
-{{- if .Values.ingress.enabled }}
-{{- range $host := .Values.ingress.hosts }}
-  {{- range .paths }}
-  http{{ if $.Values.ingress.tls }}s{{ end }}://{{ $host.host }}{{ .path }}
-  {{- end }}
-{{- end }}
-{{- else if contains "NodePort" .Values.service.type }}
-  export NODE_PORT=$(kubectl get --namespace {{ .Release.Namespace }} -o jsonpath="{.spec.ports[0].nodePort}" services {{ include "<CHARTNAME>.fullname" . }})
-  export NODE_IP=$(kubectl get nodes --namespace {{ .Release.Namespace }} -o jsonpath="{.items[0].status.addresses[0].address}")
-  echo http://$NODE_IP:$NODE_PORT
-{{- else if contains "LoadBalancer" .Values.service.type }}
-     NOTE: It may take a few minutes for the LoadBalancer IP to be available.
-           You can watch the status of by running 'kubectl get --namespace {{ .Release.Namespace }} svc -w {{ include "<CHARTNAME>.fullname" . }}'
-  export SERVICE_IP=$(kubectl get svc --namespace {{ .Release.Namespace }} {{ include "<CHARTNAME>.fullname" . }} --template "{{"{{ range (index .status.loadBalancer.ingress 0) }}{{.}}{{ end }}"}}")
-  echo http://$SERVICE_IP:{{ .Values.service.port }}
-{{- else if contains "ClusterIP" .Values.service.type }}
-  export POD_NAME=$(kubectl get pods --namespace {{ .Release.Namespace }} -l "app.kubernetes.io/name={{ include "<CHARTNAME>.name" . }},app.kubernetes.io/instance={{ .Release.Name }}" -o jsonpath="{.items[0].metadata.name}")
-  export CONTAINER_PORT=$(kubectl get pod --namespace {{ .Release.Namespace }} $POD_NAME -o jsonpath="{.spec.containers[0].ports[0].containerPort}")
-  echo "Visit http://127.0.0.1:8080 to use your application"
-  kubectl --namespace {{ .Release.Namespace }} port-forward $POD_NAME 8080:$CONTAINER_PORT
-{{- end }}
+const defaultNotes = `Release {{ .Release.Name }} of chart {{ .Chart.Name }}-{{ .Chart.Version }} is deployed to namespace {{ .Release.Namespace }}.
+Get the application URLs by running these commands:
 `
 
 const defaultHelpers = `{{/*
@@ -378,17 +657,376 @@ Create the name of the service account to use
 {{- end }}
 `
 
-const defaultAppHelpers = `{{/*
-Expand the name of the App helper.
+const defaultAppHelpers = `
+{{/*
+Name of the objects of <APPNAME>, value.fullnameOverride replaces its appname.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.fullname" -}}
+{{- .Values.<APPNAME>.value.fullnameOverride | default .Values.<APPNAME>.appname | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Selector labels of <APPNAME>. The component label is the key of the app in
+values.yaml, unique in the chart, so the apps don't select each other's pods.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.selectorLabels" -}}
+{{ include "<CHARTNAME>.selectorLabels" . }}
+app.kubernetes.io/component: {{ "<APPNAME>" | trunc 63 | trimAll "_" }}
+{{- end }}
+
+{{/*
+Labels of <APPNAME>, value.labels are added to them.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.labels" -}}
+helm.sh/chart: {{ include "<CHARTNAME>.chart" . }}
+{{ include "<CHARTNAME>.<APPNAME>.selectorLabels" . }}
+{{- with .Values.<APPNAME>.version | default .Chart.AppVersion }}
+app.kubernetes.io/version: {{ . | toString | trunc 63 | trimSuffix "-" | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- with .Values.<APPNAME>.value.labels }}
+{{ toYaml . }}
+{{- end }}
+{{- end }}
+
+{{/*
+Pod template of <APPNAME>, shared by its workloads, which include it with the
+workload kind set. The persistence volume is mounted at persistence.mountPath,
+it is claimed by the pvc kind or by the volumeClaimTemplates of a StatefulSet.
+Job pods don't restart and have no probes.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.podTemplate" -}}
+{{- $workload := get . "workload" }}
+{{- $isJob := has $workload (list "job" "cronjob") }}
+{{- $persistence := .Values.<APPNAME>.value.persistence | default dict }}
+{{- $claim := and $persistence.enabled (ne $workload "set") }}
+{{- $config := .Values.<APPNAME>.value.configMap | default dict }}
+{{- $secret := .Values.<APPNAME>.value.secret | default dict }}
+{{- /* the ConfigMap and the Secret are used when they are part of the chart or existing ones */}}
+{{- if not (or <APPCONFIGMAP> $config.name) }}
+{{- $config = dict }}
+{{- end }}
+{{- if not (or <APPSECRET> $secret.name) }}
+{{- $secret = dict }}
+{{- end }}
+{{- $ports := include "<CHARTNAME>.<APPNAME>.ports" . | fromYamlArray -}}
+metadata:
+  {{- if or .Values.<APPNAME>.value.podAnnotations $config.data $config.binaryData $secret.data $secret.binaryData }}
+  annotations:
+    {{- with .Values.<APPNAME>.value.podAnnotations }}
+    {{- toYaml . | nindent 4 }}
+    {{- end }}
+    {{- if or $config.data $config.binaryData }}
+    checksum/config: {{ pick $config "data" "binaryData" | toYaml | sha256sum }}
+    {{- end }}
+    {{- if or $secret.data $secret.binaryData }}
+    checksum/secret: {{ pick $secret "data" "binaryData" | toYaml | sha256sum }}
+    {{- end }}
+  {{- end }}
+  labels:
+    {{- include "<CHARTNAME>.<APPNAME>.selectorLabels" . | nindent 4 }}
+spec:
+  {{- if $isJob }}
+  restartPolicy: {{ (.Values.<APPNAME>.value.job | default dict).restartPolicy | default "OnFailure" }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.imagePullSecrets }}
+  imagePullSecrets:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.serviceAccount }}
+  {{- if or .create .name }}
+  serviceAccountName: {{ include "<CHARTNAME>.<APPNAME>.serviceAccountName" $ }}
+  {{- end }}
+  {{- end }}
+  containers:
+    - name: {{ .Values.<APPNAME>.appname }}
+      image: "{{ .Values.<APPNAME>.value.image.repository }}:{{ .Values.<APPNAME>.value.image.tag | default .Chart.AppVersion }}"
+      imagePullPolicy: {{ .Values.<APPNAME>.value.image.pullPolicy }}
+      {{- with .Values.<APPNAME>.value.command }}
+      command:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with .Values.<APPNAME>.value.args }}
+      args:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- with $ports }}
+      ports:
+        {{- range . }}
+        - name: {{ .name }}
+          containerPort: {{ .containerPort }}
+          protocol: {{ .protocol }}
+        {{- end }}
+      {{- end }}
+      {{- with .Values.<APPNAME>.value.env }}
+      env:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+      {{- if or $config.envFrom $secret.envFrom }}
+      envFrom:
+        {{- if $config.envFrom }}
+        - configMapRef:
+            name: {{ include "<CHARTNAME>.<APPNAME>.configMapName" . }}
+        {{- end }}
+        {{- if $secret.envFrom }}
+        - secretRef:
+            name: {{ include "<CHARTNAME>.<APPNAME>.secretName" . }}
+        {{- end }}
+      {{- end }}
+      {{- if not $isJob }}
+      {{- $port := first $ports | default dict }}
+      {{- range $name := list "livenessProbe" "readinessProbe" "startupProbe" }}
+      {{- $probe := get $.Values.<APPNAME>.value $name | default dict }}
+      {{- $enabled := or (gt (len $probe) 0) (and $port.name (ne $name "startupProbe")) }}
+      {{- if hasKey $probe "enabled" }}
+      {{- $enabled = $probe.enabled }}
+      {{- end }}
+      {{- if $enabled }}
+      {{ $name }}:
+        {{- include "<CHARTNAME>.<APPNAME>.probe" (dict "probe" $probe "port" $port "name" $name) | trim | nindent 8 }}
+      {{- end }}
+      {{- end }}
+      {{- end }}
+      resources:
+        {{- toYaml .Values.<APPNAME>.value.resources | nindent 8 }}
+      {{- if or .Values.<APPNAME>.value.volumeMounts $persistence.enabled $config.mountPath $secret.mountPath }}
+      volumeMounts:
+        {{- with .Values.<APPNAME>.value.volumeMounts }}
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
+        {{- if $persistence.enabled }}
+        - name: data
+          mountPath: {{ $persistence.mountPath | default "/data" }}
+        {{- end }}
+        {{- with $config.mountPath }}
+        - name: config
+          mountPath: {{ . }}
+          readOnly: true
+        {{- end }}
+        {{- with $secret.mountPath }}
+        - name: secret
+          mountPath: {{ . }}
+          readOnly: true
+        {{- end }}
+      {{- end }}
+  {{- if or .Values.<APPNAME>.value.volumes $claim $config.mountPath $secret.mountPath }}
+  volumes:
+    {{- with .Values.<APPNAME>.value.volumes }}
+    {{- toYaml . | nindent 4 }}
+    {{- end }}
+    {{- if $claim }}
+    - name: data
+      persistentVolumeClaim:
+        claimName: {{ $persistence.existingClaim | default (printf "%s-data" (include "<CHARTNAME>.<APPNAME>.fullname" .)) }}
+    {{- end }}
+    {{- if $config.mountPath }}
+    - name: config
+      configMap:
+        name: {{ include "<CHARTNAME>.<APPNAME>.configMapName" . }}
+    {{- end }}
+    {{- if $secret.mountPath }}
+    - name: secret
+      secret:
+        secretName: {{ include "<CHARTNAME>.<APPNAME>.secretName" . }}
+    {{- end }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.nodeSelector }}
+  nodeSelector:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.affinity }}
+  affinity:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+  {{- with .Values.<APPNAME>.value.tolerations }}
+  tolerations:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+{{- end }}
+
+{{/*
+Ports of <APPNAME> from value.ports, with their defaults filled in. Without
+value.ports the app has a single port named http on value.containerPort,
+exposed by the Service on value.service.port.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.ports" -}}
+{{- $value := .Values.<APPNAME>.value }}
+{{- $ports := $value.ports }}
+{{- if not (hasKey $value "ports") }}
+{{- $port := $value.containerPort | default 80 }}
+{{- $ports = list (dict "name" "http" "containerPort" $port "servicePort" (($value.service | default dict).port | default $port)) }}
+{{- end }}
+{{- range $ports }}
+{{- $protocol := .protocol | default "TCP" }}
+- name: {{ .name | default (printf "%s-%v" (lower $protocol) .containerPort) }}
+  containerPort: {{ required "value.ports[].containerPort of <APPNAME> is required" .containerPort }}
+  protocol: {{ $protocol }}
+  servicePort: {{ .servicePort | default .containerPort }}
+  expose: {{ if hasKey . "expose" }}{{ .expose }}{{ else }}true{{ end }}
+  {{- with .nodePort }}
+  nodePort: {{ . }}
+  {{- end }}
+  {{- with .appProtocol }}
+  appProtocol: {{ . }}
+  {{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Port of the Service of <APPNAME> used by its Ingress and connection test.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.servicePort" -}}
+{{- (first (include "<CHARTNAME>.<APPNAME>.ports" . | fromYamlArray) | default dict).servicePort }}
+{{- end }}
+
+{{/*
+Probe of <APPNAME>, called with the probe values, the first port and the probe
+name. A probe with an httpGet, tcpSocket, exec or grpc handler is used as it is,
+otherwise its handler is built from type (httpGet by default), path, port and
+command.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.probe" -}}
+{{- $probe := .probe }}
+{{- if or $probe.httpGet $probe.tcpSocket $probe.exec $probe.grpc }}
+{{- toYaml (omit $probe "enabled" "type") }}
+{{- else }}
+{{- $type := $probe.type | default "httpGet" }}
+{{- $field := printf "value.%s of <APPNAME>" .name }}
+{{- if eq $type "httpGet" }}
+httpGet:
+  path: {{ $probe.path | default "/" }}
+  port: {{ $probe.port | default .port.name | required (printf "%s needs a port" $field) }}
+  {{- with $probe.scheme }}
+  scheme: {{ . }}
+  {{- end }}
+  {{- with $probe.httpHeaders }}
+  httpHeaders:
+    {{- toYaml . | nindent 4 }}
+  {{- end }}
+{{- else if eq $type "tcpSocket" }}
+tcpSocket:
+  port: {{ $probe.port | default .port.name | required (printf "%s needs a port" $field) }}
+{{- else if eq $type "grpc" }}
+grpc:
+  port: {{ $probe.port | default .port.containerPort | required (printf "%s needs a port" $field) }}
+  {{- with $probe.service }}
+  service: {{ . | quote }}
+  {{- end }}
+{{- else if eq $type "exec" }}
+exec:
+  command:
+    {{- toYaml ($probe.command | required (printf "%s needs a command" $field)) | nindent 4 }}
+{{- else }}
+{{- fail (printf "%s has an unknown type %q, expected httpGet, tcpSocket, exec or grpc" $field $type) }}
+{{- end }}
+{{- range $key := list "initialDelaySeconds" "periodSeconds" "timeoutSeconds" "successThreshold" "failureThreshold" "terminationGracePeriodSeconds" }}
+{{- if hasKey $probe $key }}
+{{ $key }}: {{ get $probe $key }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the ServiceAccount of <APPNAME>, created when value.serviceAccount.create is set.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.serviceAccountName" -}}
+{{- $serviceAccount := .Values.<APPNAME>.value.serviceAccount | default dict }}
+{{- if $serviceAccount.create }}
+{{- $serviceAccount.name | default (include "<CHARTNAME>.<APPNAME>.fullname" .) }}
+{{- else }}
+{{- $serviceAccount.name | default "default" }}
+{{- end }}
+{{- end }}
+
+{{/*
+Name of the ConfigMap of <APPNAME>, value.configMap.name refers to an existing one.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.configMapName" -}}
+{{- (.Values.<APPNAME>.value.configMap | default dict).name | default (printf "%s-config" (include "<CHARTNAME>.<APPNAME>.fullname" .)) }}
+{{- end }}
+
+{{/*
+Name of the Secret of <APPNAME>, value.secret.name refers to an existing one.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.secretName" -}}
+{{- (.Values.<APPNAME>.value.secret | default dict).name | default (printf "%s-secret" (include "<CHARTNAME>.<APPNAME>.fullname" .)) }}
+{{- end }}
+
+{{/*
+Job spec of <APPNAME>, shared by its Job and CronJob, set by value.job.
+*/}}
+{{- define "<CHARTNAME>.<APPNAME>.jobSpec" -}}
+{{- $job := .Values.<APPNAME>.value.job | default dict }}
+{{- if hasKey $job "backoffLimit" }}
+backoffLimit: {{ $job.backoffLimit }}
+{{- end }}
+{{- if hasKey $job "activeDeadlineSeconds" }}
+activeDeadlineSeconds: {{ $job.activeDeadlineSeconds }}
+{{- end }}
+{{- if hasKey $job "ttlSecondsAfterFinished" }}
+ttlSecondsAfterFinished: {{ $job.ttlSecondsAfterFinished }}
+{{- end }}
+{{- if hasKey $job "completions" }}
+completions: {{ $job.completions }}
+{{- end }}
+{{- if hasKey $job "parallelism" }}
+parallelism: {{ $job.parallelism }}
+{{- end }}
+template:
+  {{- include "<CHARTNAME>.<APPNAME>.podTemplate" . | nindent 2 }}
+{{- end }}
+`
+
+// defaultAppNotes are the NOTES.txt lines of an app, see WriteNotesFile
+const defaultAppNotes = `
+{{ .Values.<APPNAME>.appname }}:
+`
+
+const defaultAppNotesIngress = `{{- $ingress := .Values.<APPNAME>.value.ingress | default dict }}
+{{- range $host := $ingress.hosts }}
+  {{- range .paths }}
+  http{{ if $ingress.tls }}s{{ end }}://{{ $host.host }}{{ .path }}
+  {{- end }}
+{{- end }}
+`
+
+const defaultAppNotesService = `{{- with .Values.<APPNAME>.value.service | default (dict "type" "ClusterIP") }}
+{{- if eq (.type | default "ClusterIP") "NodePort" }}
+  export NODE_PORT=$(kubectl get --namespace {{ $.Release.Namespace }} -o jsonpath="{.spec.ports[0].nodePort}" services {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }})
+  export NODE_IP=$(kubectl get nodes --namespace {{ $.Release.Namespace }} -o jsonpath="{.items[0].status.addresses[0].address}")
+  echo http://$NODE_IP:$NODE_PORT
+{{- else if eq (.type | default "ClusterIP") "LoadBalancer" }}
+  NOTE: It may take a few minutes for the LoadBalancer IP to be available.
+        You can watch the status of by running 'kubectl get --namespace {{ $.Release.Namespace }} svc -w {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }}'
+  export SERVICE_IP=$(kubectl get svc --namespace {{ $.Release.Namespace }} {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }} --template "{{"{{ range (index .status.loadBalancer.ingress 0) }}{{.}}{{ end }}"}}")
+  echo http://$SERVICE_IP:{{ include "<CHARTNAME>.<APPNAME>.servicePort" $ }}
+{{- else }}
+  kubectl --namespace {{ $.Release.Namespace }} port-forward svc/{{ include "<CHARTNAME>.<APPNAME>.fullname" $ }} 8080:{{ include "<CHARTNAME>.<APPNAME>.servicePort" $ }}
+  echo "Visit http://127.0.0.1:8080 to use {{ include "<CHARTNAME>.<APPNAME>.fullname" $ }}"
+{{- end }}
+{{- end }}
+`
+
+const defaultAppNotesPods = `  kubectl get pods --namespace {{ .Release.Namespace }} -l "{{ include "<CHARTNAME>.<APPNAME>.selectorLabels" . | replace ": " "=" | replace "\n" "," }}"
+`
+
+// defaultUserHelpers ends _helpers.tpl with a user region, kept when the chart is regenerated
+const defaultUserHelpers = `
+{{/*
+Helpers added between the helm-maker:begin and helm-maker:end lines are kept
+when the chart is regenerated.
 */}}
+{{/* helm-maker:begin user */}}
+{{/* helm-maker:end user */}}
 `
 
 const defaultTestConnection = `apiVersion: v1
 kind: Pod
 metadata:
-  name: "{{ include "<CHARTNAME>.fullname" . }}-test-connection"
+  name: "{{ include "<CHARTNAME>.<APPNAME>.fullname" . }}-test-connection"
   labels:
-    {{- include "<CHARTNAME>.labels" . | nindent 4 }}
+    {{- include "<CHARTNAME>.<APPNAME>.labels" . | nindent 4 }}
   annotations:
     "helm.sh/hook": test
 spec:
@@ -396,7 +1034,7 @@ spec:
     - name: wget
       image: busybox
       command: ['wget']
-      args: ['{{ include "<CHARTNAME>.fullname" . }}:{{ .Values.service.port }}']
+      args: ['{{ include "<CHARTNAME>.<APPNAME>.fullname" . }}:{{ include "<CHARTNAME>.<APPNAME>.servicePort" . }}']
   restartPolicy: Never
 `
 
@@ -0,0 +1,321 @@
+package chart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	valuesDocOnce sync.Once
+	// valuesDoc documents the values of an app, its key order and comments
+	// are the ones of defaultAppValues and defaultValues
+	valuesDoc *yaml.Node
+
+	chartfileDocOnce sync.Once
+	// chartfileDocNode documents Chart.yaml with defaultChartfile
+	chartfileDocNode *yaml.Node
+)
+
+// appValuesDoc returns the documentation of the values of an app
+func appValuesDoc() *yaml.Node {
+	valuesDocOnce.Do(func() {
+		valuesDoc = mustParseMapping(defaultAppValues)
+		if _, value := mappingPair(valuesDoc, "value"); value != nil {
+			*value = *mustParseMapping(defaultValues)
+		}
+	})
+	return valuesDoc
+}
+
+// chartfileDoc returns the documentation of Chart.yaml
+func chartfileDoc() *yaml.Node {
+	chartfileDocOnce.Do(func() {
+		chartfileDocNode = mustParseMapping(fmt.Sprintf(defaultChartfile, "chart"))
+	})
+	return chartfileDocNode
+}
+
+func mustParseMapping(s string) *yaml.Node {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		panic(err)
+	}
+	return doc.Content[0]
+}
+
+// valuesContent is the values.yaml of the apps. The values of each app are
+// under its name, their keys in the order of the spec, then of the documented
+// values, then of the alphabet. A key is commented with its comment in the
+// spec, its description in App.Schema or its documentation. base is a
+// hand-written values file the values of the apps are merged into, its keys
+// and comments are kept.
+func valuesContent(apps *Apps, base []byte) ([]byte, error) {
+	doc, err := parseBaseValues(base)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	for _, app := range apps.Sets {
+		values := app.Values
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		n := new(yaml.Node)
+		if err := n.Encode(values); err != nil {
+			return nil, errors.Wrapf(err, "marshal values of %s", app.Name)
+		}
+		decorateValues(n, app.valuesNode, appValuesDoc(), "", app.Schema)
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: app.Name, HeadComment: appValuesHeader(app)}
+		if k, v := mappingPair(root, app.Name); v != nil {
+			if k.HeadComment == "" {
+				k.HeadComment = key.HeadComment
+			}
+			mergeValuesNode(v, n)
+			continue
+		}
+		root.Content = append(root.Content, key, n)
+	}
+	return encodeValues(doc)
+}
+
+// orderedYAML marshals v like sigs.k8s.io/yaml does, with its keys in the
+// order of doc and the comments of doc
+func orderedYAML(v interface{}, doc *yaml.Node) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	n := new(yaml.Node)
+	if err := n.Encode(m); err != nil {
+		return nil, err
+	}
+	decorateValues(n, nil, doc, "", nil)
+	return encodeValues(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{n}})
+}
+
+// encodeValues writes a values document with an indent of 2 and a blank line
+// before the commented keys of its root, yaml.v3 keeps no blank lines
+func encodeValues(doc *yaml.Node) ([]byte, error) {
+	root := doc.Content[0]
+	var b bytes.Buffer
+	if doc.HeadComment != "" {
+		b.WriteString(doc.HeadComment + "\n\n")
+	}
+	parts := []*yaml.Node{root}
+	if len(root.Content) > 0 {
+		parts = parts[:0]
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			parts = append(parts, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: root.Content[i : i+2]})
+		}
+		parts[0].HeadComment = root.HeadComment
+		parts[len(parts)-1].FootComment = root.FootComment
+	}
+	for i, n := range parts {
+		if i > 0 && (n.HeadComment != "" || n.Content[0].HeadComment != "") {
+			b.WriteString("\n")
+		}
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(n); err != nil {
+			return nil, errors.Wrap(err, "marshal values")
+		}
+		if err := enc.Close(); err != nil {
+			return nil, errors.Wrap(err, "marshal values")
+		}
+	}
+	if doc.FootComment != "" {
+		b.WriteString("\n" + doc.FootComment + "\n")
+	}
+	return b.Bytes(), nil
+}
+
+// parseBaseValues parses a base values file into a document of a mapping,
+// an empty one when there is no base
+func parseBaseValues(base []byte) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	if len(bytes.TrimSpace(base)) > 0 {
+		if err := yaml.Unmarshal(base, doc); err != nil {
+			return nil, errors.Wrap(err, "parse base values")
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.Errorf("base values must be a mapping, got %s", kindName(doc.Content[0]))
+	}
+	return doc, nil
+}
+
+// appValuesHeader is the comment of the section of an app in values.yaml
+func appValuesHeader(app *App) string {
+	parts := append([]string{}, app.Types...)
+	templates := make([]string, 0, len(app.Templates))
+	for name := range app.Templates {
+		templates = append(templates, name)
+	}
+	sort.Strings(templates)
+	parts = append(parts, templates...)
+	return "# " + app.Name + ": " + strings.Join(parts, ", ")
+}
+
+// decorateValues orders the keys of the values n at the dotted value path p
+// and comments them, spec is the node of the values in the spec and doc their
+// documentation, both may be nil
+func decorateValues(n, spec, doc *yaml.Node, p string, schema map[string]map[string]interface{}) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		type pair struct {
+			key, val *yaml.Node
+			rank     int
+		}
+		pairs := make([]pair, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			pairs = append(pairs, pair{n.Content[i], n.Content[i+1], keyRank(n.Content[i].Value, spec, doc)})
+		}
+		// the encoded keys are sorted, the keys of the same rank stay so
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].rank < pairs[j].rank })
+		n.Content = n.Content[:0]
+		for _, pr := range pairs {
+			key, val := pr.key, pr.val
+			kp := joinField(p, key.Value)
+			specKey, specVal := mappingPair(spec, key.Value)
+			docKey, docVal := mappingPair(doc, key.Value)
+			desc, _ := schema[kp]["description"].(string)
+			switch {
+			case specKey != nil && specKey.HeadComment != "":
+				key.HeadComment = specKey.HeadComment
+			case desc != "":
+				key.HeadComment = "# " + strings.ReplaceAll(strings.TrimSpace(desc), "\n", "\n# ")
+			case docKey != nil:
+				key.HeadComment = docKey.HeadComment
+			}
+			if specKey != nil {
+				key.LineComment = specKey.LineComment
+				if specVal.Kind == yaml.ScalarNode && val.Kind == yaml.ScalarNode {
+					val.LineComment = specVal.LineComment
+				}
+			}
+			// the commented examples of an empty value show how to fill it
+			if (val.Kind == yaml.MappingNode || val.Kind == yaml.SequenceNode) && len(val.Content) == 0 {
+				switch {
+				case specKey != nil && specKey.FootComment != "":
+					key.FootComment = specKey.FootComment
+				case docKey != nil:
+					key.FootComment = docKey.FootComment
+				}
+			}
+			decorateValues(val, specVal, docVal, kp, schema)
+			n.Content = append(n.Content, key, val)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			var specItem *yaml.Node
+			if spec != nil && spec.Kind == yaml.SequenceNode && i < len(spec.Content) {
+				specItem = resolveAlias(spec.Content[i])
+				item.HeadComment = specItem.HeadComment
+			}
+			decorateValues(item, specItem, nil, p+"[]", schema)
+		}
+	}
+}
+
+// keyRank is the position of a key: the keys of the spec first, then the
+// documented keys, then the others
+func keyRank(key string, spec, doc *yaml.Node) int {
+	spec, doc = resolveAlias(spec), resolveAlias(doc)
+	if i := keyIndex(spec, key); i >= 0 {
+		return i
+	}
+	offset := 0
+	if spec != nil {
+		offset = len(spec.Content) / 2
+	}
+	if i := keyIndex(doc, key); i >= 0 {
+		return offset + i
+	}
+	if doc != nil {
+		offset += len(doc.Content) / 2
+	}
+	return offset
+}
+
+func keyIndex(n *yaml.Node, key string) int {
+	n = resolveAlias(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i / 2
+		}
+	}
+	return -1
+}
+
+// mappingPair returns the key and value nodes of a key of a mapping node
+func mappingPair(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	n = resolveAlias(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n != nil && n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// mergeValuesNode merges the generated values src into the values dst of a
+// base values file. Mappings are merged key by key, the other values of src
+// replace the ones of dst, the comments of dst are kept.
+func mergeValuesNode(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		if head != "" {
+			dst.HeadComment = head
+		}
+		if line != "" {
+			dst.LineComment = line
+		}
+		if foot != "" {
+			dst.FootComment = foot
+		}
+		return
+	}
+	if len(dst.Content) == 0 {
+		dst.Style = src.Style
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, val := src.Content[i], src.Content[i+1]
+		if k, v := mappingPair(dst, key.Value); v != nil {
+			if k.HeadComment == "" {
+				k.HeadComment = key.HeadComment
+			}
+			mergeValuesNode(v, val)
+			continue
+		}
+		dst.Content = append(dst.Content, key, val)
+	}
+}
@@ -0,0 +1,290 @@
+package chart
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// valueRule is a schema the values at the paths matching pattern are given, on
+// top of the type inferred from the value. The pattern is a path.Match pattern
+// of the value path of an app, like value/ports/#/containerPort, the items of
+// a list are #.
+type valueRule struct {
+	pattern string
+	schema  map[string]interface{}
+	// required makes the value a required key of its parent
+	required bool
+}
+
+func portRange() map[string]interface{} {
+	return map[string]interface{}{"minimum": 1, "maximum": 65535}
+}
+
+func enum(values ...string) map[string]interface{} {
+	return map[string]interface{}{"enum": values}
+}
+
+// valueRules are the schemas of the values the templates know
+var valueRules = []valueRule{
+	{pattern: "appname", required: true},
+	{pattern: "value", required: true},
+	{pattern: "version", schema: map[string]interface{}{"type": []string{"string", "number"}}},
+	{pattern: "value/image/repository", required: true},
+	{pattern: "value/image/tag", schema: map[string]interface{}{"type": []string{"string", "number"}}},
+	{pattern: "value/image/pullPolicy", schema: enum("Always", "IfNotPresent", "Never")},
+	{pattern: "value/replicaCount", schema: map[string]interface{}{"minimum": 0}},
+	{pattern: "value/containerPort", schema: portRange()},
+	{pattern: "value/service/type", schema: enum("ClusterIP", "NodePort", "LoadBalancer", "ExternalName")},
+	{pattern: "value/service/port", schema: portRange()},
+	{pattern: "value/service/nodePort", schema: portRange()},
+	{pattern: "value/ports/#/containerPort", schema: portRange(), required: true},
+	{pattern: "value/ports/#/servicePort", schema: portRange()},
+	{pattern: "value/ports/#/nodePort", schema: portRange()},
+	{pattern: "value/ports/#/protocol", schema: enum("TCP", "UDP", "SCTP")},
+	{pattern: "value/*Probe/type", schema: enum("httpGet", "tcpSocket", "exec", "grpc")},
+	{pattern: "value/*Probe/*Seconds", schema: map[string]interface{}{"minimum": 0}},
+	{pattern: "value/*Probe/*Threshold", schema: map[string]interface{}{"minimum": 1}},
+	{pattern: "value/autoscaling/minReplicas", schema: map[string]interface{}{"minimum": 1}},
+	{pattern: "value/autoscaling/maxReplicas", schema: map[string]interface{}{"minimum": 1}},
+	{pattern: "value/autoscaling/target*UtilizationPercentage", schema: map[string]interface{}{"minimum": 1, "maximum": 100}},
+	{pattern: "value/podManagementPolicy", schema: enum("OrderedReady", "Parallel")},
+	{pattern: "value/job/restartPolicy", schema: enum("OnFailure", "Never")},
+	{pattern: "value/job/backoffLimit", schema: map[string]interface{}{"minimum": 0}},
+	{pattern: "value/cronjob/restartPolicy", schema: enum("OnFailure", "Never")},
+	{pattern: "value/cronjob/concurrencyPolicy", schema: enum("Allow", "Forbid", "Replace")},
+}
+
+// valuesSchemaContent is the values.schema.json of the apps. The schema of the
+// values of each app is inferred from them: their types, the valueRules and
+// the annotations of App.Schema. Objects may have other keys than the ones of
+// the values, so overrides can add them.
+func valuesSchemaContent(apps *Apps) ([]byte, error) {
+	root := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+	}
+	props := map[string]interface{}{}
+	var required []string
+	for _, app := range apps.Sets {
+		s := inferSchema(app.Values, "")
+		for p, keywords := range app.Schema {
+			if err := annotateSchema(s, p, keywords); err != nil {
+				return nil, &GenerateError{App: app.Name, Path: SchemafileName, Err: err}
+			}
+		}
+		props[app.Name] = s
+		required = append(required, app.Name)
+	}
+	root["properties"] = props
+	if len(required) > 0 {
+		sort.Strings(required)
+		root["required"] = required
+	}
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, &GenerateError{Path: SchemafileName, Err: err}
+	}
+	return append(data, '\n'), nil
+}
+
+// inferSchema is the schema of a value at the value path p. Empty strings,
+// nulls and empty collections are placeholders, like configMap: "" of an app
+// whose ConfigMap is set by an override, they give no type.
+func inferSchema(v interface{}, p string) map[string]interface{} {
+	s := map[string]interface{}{}
+	switch v := v.(type) {
+	case nil:
+		if p == "" {
+			s["type"] = "object"
+		}
+	case map[string]interface{}:
+		if len(v) > 0 || p == "" {
+			s["type"] = "object"
+		}
+		props := map[string]interface{}{}
+		var required []string
+		for k, item := range v {
+			kp := path.Join(p, k)
+			props[k] = inferSchema(item, kp)
+			if ruleRequired(kp) {
+				required = append(required, k)
+			}
+		}
+		if len(props) > 0 {
+			s["properties"] = props
+		}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			s["type"] = "array"
+		}
+		if items := inferItems(v, path.Join(p, "#")); len(items) > 0 {
+			s["items"] = items
+		}
+	case string:
+		if v != "" {
+			s["type"] = "string"
+		}
+	case bool:
+		s["type"] = "boolean"
+	case int, int32, int64, uint, uint32, uint64:
+		s["type"] = "integer"
+	case float32, float64:
+		s["type"] = "number"
+	}
+	for _, r := range valueRules {
+		if r.schema != nil && matchValuePath(r.pattern, p) {
+			for k, kv := range r.schema {
+				s[k] = kv
+			}
+		}
+	}
+	return s
+}
+
+// inferItems is the schema of the items of a list, the items which are
+// objects are merged, the items of different types give no schema
+func inferItems(items []interface{}, p string) map[string]interface{} {
+	if len(items) == 0 {
+		return nil
+	}
+	merged := map[string]interface{}{}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			merged = nil
+			break
+		}
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	if merged != nil {
+		s := inferSchema(merged, p)
+		// every item must have the required keys, the others only of some items
+		var required []string
+		if r, ok := s["required"].([]string); ok {
+			for _, k := range r {
+				all := true
+				for _, item := range items {
+					if _, ok := item.(map[string]interface{})[k]; !ok {
+						all = false
+					}
+				}
+				if all {
+					required = append(required, k)
+				}
+			}
+		}
+		delete(s, "required")
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	first := inferSchema(items[0], p)
+	for _, item := range items[1:] {
+		if s := inferSchema(item, p); s["type"] != first["type"] {
+			return nil
+		}
+	}
+	return first
+}
+
+func matchValuePath(pattern, p string) bool {
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+func ruleRequired(p string) bool {
+	for _, r := range valueRules {
+		if r.required && matchValuePath(r.pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateSchema merges the keywords into the schema of the value at the
+// dotted path p, like value.ports[].name. The keyword required: true makes the
+// value a required key of its parent, false an optional one.
+func annotateSchema(s map[string]interface{}, p string, keywords map[string]interface{}) error {
+	segments := strings.Split(strings.ReplaceAll(p, "[]", ".[]"), ".")
+	parent, key := s, ""
+	for i, seg := range segments {
+		if seg == "" {
+			return errors.Errorf("schema %q: invalid value path", p)
+		}
+		if i == len(segments)-1 && seg != "[]" {
+			key = seg
+		}
+		var child map[string]interface{}
+		if seg == "[]" {
+			if t, ok := s["type"]; ok && t != "array" {
+				return errors.Errorf("schema %q: %s is not a list", p, strings.Join(segments[:i], "."))
+			}
+			s["type"] = "array"
+			child, _ = s["items"].(map[string]interface{})
+			if child == nil {
+				child = map[string]interface{}{}
+				s["items"] = child
+			}
+		} else {
+			if t, ok := s["type"]; ok && t != "object" {
+				return errors.Errorf("schema %q: %s is not an object", p, strings.Join(segments[:i], "."))
+			}
+			s["type"] = "object"
+			props, _ := s["properties"].(map[string]interface{})
+			if props == nil {
+				props = map[string]interface{}{}
+				s["properties"] = props
+			}
+			child, _ = props[seg].(map[string]interface{})
+			if child == nil {
+				child = map[string]interface{}{}
+				props[seg] = child
+			}
+		}
+		parent, s = s, child
+	}
+	for k, v := range keywords {
+		if k != "required" {
+			s[k] = v
+			continue
+		}
+		req, ok := v.(bool)
+		if !ok || key == "" {
+			return errors.Errorf("schema %q: required must be true or false on an object key", p)
+		}
+		setRequired(parent, key, req)
+	}
+	return nil
+}
+
+// setRequired adds or removes key from the required keys of an object schema
+func setRequired(s map[string]interface{}, key string, required bool) {
+	var keys []string
+	if r, ok := s["required"].([]string); ok {
+		for _, k := range r {
+			if k != key {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if required {
+		keys = append(keys, key)
+		sort.Strings(keys)
+	}
+	if len(keys) == 0 {
+		delete(s, "required")
+		return
+	}
+	s["required"] = keys
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"helm-maker/chart"
+)
+
+func init() {
+	register(&command{
+		name:  "generate",
+		short: "generate a chart from --spec (file, directory or - for stdin), --pipeline or --manifests into --output-dir",
+		flags: func(o *options) {
+			o.flags.StringVar(&o.pipeline, "pipeline", "", "CI pipeline document to convert into a chart")
+			o.flags.BoolVar(&o.valuesPerEnv, "values-per-env", false, "with --pipeline, generate one app and a values file per environment instead of one app per deploy node")
+			o.flags.StringVar(&o.manifests, "manifests", "", "file, directory or - for stdin of plain kubernetes manifests to import into a chart")
+			o.flags.StringVar(&o.name, "name", "", "with --manifests, the name of the chart (default: the base name of the manifests directory)")
+			o.flags.BoolVar(&o.force, "force", false, "overwrite the files edited by hand and the files not generated by helm-maker")
+			o.flags.BoolVar(&o.dryRun, "dry-run", false, "write nothing, print the unified diff against the existing chart and exit with 3 when they differ")
+			o.flags.StringVar(&o.summary, "summary", "", "with --dry-run, write a JSON summary of the added, changed, removed and edited files to this file, - prints it instead of the diff")
+			o.flags.BoolVar(&o.check, "check", false, "lint the chart and validate its manifests before it is written, see lint")
+			o.checkFlags()
+			o.flags.BoolVar(&o.bump, "bump", false, "bump the chart version from the previous chart by how it changed: patch for values, minor for added apps and templates, major for removed ones")
+			o.flags.StringVar(&o.commit, "commit", "", "with --bump, the git commit (like $COMMIT_ID) the prerelease of a bumped version is built from")
+			o.flags.StringVar(&o.previous, "previous", "", "with --bump, the repository index (index.yaml or repository URL) holding the previous chart, instead of the chart in --output-dir")
+		},
+		run: runGenerate,
+	})
+	register(&command{
+		name:  "lint",
+		args:  "CHART",
+		short: "examine a chart for possible issues and validate its manifests against the Kubernetes OpenAPI schema",
+		flags: func(o *options) {
+			o.flags.BoolVar(&o.strict, "strict", false, "fail on lint warnings, like --fail-on warning")
+			o.checkFlags()
+		},
+		run: runLint,
+	})
+	register(&command{
+		name:  "template",
+		args:  "RELEASE CHART",
+		short: "render chart templates locally and print the manifests",
+		flags: func(o *options) {
+			o.renderFlags()
+		},
+		run: runTemplate,
+	})
+	register(&command{
+		name:  "package",
+		args:  "CHART",
+		short: "package a chart directory into a chart archive in --output-dir",
+		flags: func(o *options) {
+			o.dependencyFlags()
+		},
+		run: runPackage,
+	})
+}
+
+// renderFlags registers the flags of the cluster a chart is rendered for
+func (o *options) renderFlags() {
+	o.flags.StringVar(&o.kubeVersion, "kube-version", "", "Kubernetes version used for Capabilities.KubeVersion, like v1.22.0")
+	o.flags.Var(&o.apiVersions, "api-versions", "Kubernetes API version added to Capabilities.APIVersions, may be repeated")
+	o.flags.Var(&o.apiVersions, "a", "shorthand for --api-versions")
+}
+
+// checkFlags registers the flags of checking a chart
+func (o *options) checkFlags() {
+	o.renderFlags()
+	o.flags.Lookup("kube-version").Usage = "Kubernetes version used for Capabilities.KubeVersion and validated for, like v1.22.0 (default v1.21.2). " +
+		"The bundled schemas are of v1.21, the other versions are validated with the nearest one and a warning"
+	o.flags.StringVar(&o.schema, "schema", "", "OpenAPI v2 document of the cluster (kubectl get --raw /openapi/v2) to validate the manifests with, instead of the bundled schema of --kube-version")
+	o.flags.StringVar(&o.failOn, "fail-on", "error", "lowest severity of the problems which fail: none, info, warning or error")
+}
+
+// checkOptions are the options of checking a chart from the flags
+func (o *options) checkOptions() (chart.CheckOptions, error) {
+	failOn, err := chart.ParseSeverity(o.failOn)
+	if err != nil {
+		return chart.CheckOptions{}, fmt.Errorf("%w: --fail-on: %v", errUsage, err)
+	}
+	if o.strict && failOn > chart.SeverityWarning {
+		failOn = chart.SeverityWarning
+	}
+	return chart.CheckOptions{
+		RenderOptions: chart.RenderOptions{
+			Namespace:   o.namespace,
+			KubeVersion: o.kubeVersion,
+			APIVersions: o.apiVersions,
+		},
+		SchemaFile: o.schema,
+		FailOn:     failOn,
+	}, nil
+}
+
+// loadApps reads the apps from --spec, --pipeline or --manifests, the demo apps are used when none is given
+func (o *options) loadApps() (*chart.Apps, error) {
+	var sources int
+	for _, s := range []string{o.specFile, o.pipeline, o.manifests} {
+		if s != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return nil, fmt.Errorf("%w: only one of --spec, --pipeline and --manifests can be used", errUsage)
+	case o.manifests != "":
+		name := o.name
+		if name == "" && o.manifests != "-" {
+			if abs, err := filepath.Abs(o.manifests); err == nil {
+				name = filepath.Base(abs)
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%w: --name is required when reading manifests from stdin", errUsage)
+		}
+		objs, err := chart.LoadManifests(o.manifests)
+		if err != nil {
+			return nil, err
+		}
+		return chart.ImportManifests(name, objs)
+	case o.pipeline != "":
+		p, err := chart.LoadPipeline(o.pipeline)
+		if err != nil {
+			return nil, err
+		}
+		mode := chart.PipelineAppPerNode
+		if o.valuesPerEnv {
+			mode = chart.PipelineValuesPerEnv
+		}
+		return p.Apps(mode)
+	case o.specFile != "":
+		return chart.LoadSpec(o.specFile)
+	}
+	return chart.InitApps(), nil
+}
+
+func runGenerate(o *options, args []string) error {
+	if err := exactArgs(args, 0); err != nil {
+		return err
+	}
+	apps, err := o.loadApps()
+	if err != nil {
+		return err
+	}
+	if o.outputDir != "" {
+		apps.Path = o.outputDir
+	} else if apps.Path == "" {
+		apps.Path = "."
+	}
+	var opts []chart.GenerateOpt
+	if o.force {
+		opts = append(opts, chart.WithForce())
+	}
+	bump, err := o.bumpOptions(apps)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, bump...)
+	if o.dryRun {
+		return o.diff(apps, opts)
+	}
+	if o.summary != "" {
+		return fmt.Errorf("%w: --summary requires --dry-run", errUsage)
+	}
+	if err := os.MkdirAll(apps.Path, 0755); err != nil {
+		return err
+	}
+	if o.check {
+		h, err := o.helm()
+		if err != nil {
+			return err
+		}
+		copts, err := o.checkOptions()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, chart.WithCheck(h, copts))
+	}
+	report, err := chart.Generate(apps, opts...)
+	if report != nil && report.Check != nil {
+		fmt.Fprint(stdout, report.Check)
+	}
+	if report != nil && report.Version != nil {
+		fmt.Fprint(stdout, report.Version)
+	}
+	if report != nil && !errors.Is(err, chart.ErrCheckFailed) {
+		fmt.Fprint(stdout, report)
+	}
+	return err
+}
+
+// bumpOptions are the options of bumping the chart version from the flags
+func (o *options) bumpOptions(apps *chart.Apps) ([]chart.GenerateOpt, error) {
+	if !o.bump {
+		if o.commit != "" || o.previous != "" {
+			return nil, fmt.Errorf("%w: --commit and --previous require --bump", errUsage)
+		}
+		return nil, nil
+	}
+	opts := []chart.GenerateOpt{chart.WithBump(chart.Prerelease(o.commit))}
+	if o.previous != "" {
+		h, err := o.helm()
+		if err != nil {
+			return nil, err
+		}
+		prev, err := h.LatestChart(o.previous, apps.Name)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			opts = append(opts, chart.WithPrevious(prev))
+		}
+	}
+	return opts, nil
+}
+
+// diff prints what generating the apps would change, the drift is an errDrift
+func (o *options) diff(apps *chart.Apps, opts []chart.GenerateOpt) error {
+	d, err := chart.Diff(apps, opts...)
+	if err != nil {
+		return err
+	}
+	if o.summary != "-" {
+		if d.Version != nil {
+			fmt.Fprint(stdout, d.Version)
+		}
+		fmt.Fprint(stdout, d)
+	}
+	if o.summary != "" {
+		summary, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		summary = append(summary, '\n')
+		if o.summary == "-" {
+			_, err = stdout.Write(summary)
+		} else {
+			err = ioutil.WriteFile(o.summary, summary, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if d.Drift {
+		return fmt.Errorf("%w: %s: %d added, %d changed, %d removed, %d edited", errDrift, d.Dir, d.Added, d.Changed, d.Removed, d.Edited)
+	}
+	return nil
+}
+
+func runLint(o *options, args []string) error {
+	if err := exactArgs(args, 1); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	opts, err := o.checkOptions()
+	if err != nil {
+		return err
+	}
+	result, err := h.Check(args[0], opts)
+	if result != nil {
+		fmt.Fprint(stdout, result)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+func runTemplate(o *options, args []string) error {
+	if err := exactArgs(args, 2); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	r, err := h.Render(args[1], chart.RenderOptions{
+		ReleaseName: args[0],
+		Namespace:   o.namespace,
+		KubeVersion: o.kubeVersion,
+		APIVersions: o.apiVersions,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stdout, r)
+	return nil
+}
+
+func runPackage(o *options, args []string) error {
+	if err := exactArgs(args, 1); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	dest := o.outputDir
+	if dest == "" {
+		dest = "."
+	}
+	file, err := h.Package(args[0], dest, o.dependencyOptions())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Successfully packaged chart and saved it to: %s\n", file)
+	return nil
+}
@@ -1,12 +1,245 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
 	"helm-maker/chart"
+
+	"helm.sh/helm/v3/pkg/cli"
 )
 
+// exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	exitDrift = 3
+)
+
+// errUsage marks an error caused by bad command line input
+var errUsage = errors.New("usage error")
+
+// errDrift marks a chart which differs from the chart its spec generates
+var errDrift = errors.New("chart drift")
+
+var stdout io.Writer = os.Stdout
+
+type command struct {
+	name  string
+	args  string
+	short string
+	// flags registers the flags only this command understands
+	flags func(o *options)
+	run   func(o *options, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func register(c *command) {
+	commands[c.name] = c
+}
+
+// options are the flags shared by every sub command
+type options struct {
+	flags       *flag.FlagSet
+	specFile    string
+	outputDir   string
+	namespace   string
+	kubeContext string
+	kubeConfig  string
+	valueFiles  stringSlice
+	setValues   stringSlice
+	setStrings  stringSlice
+	setFiles    stringSlice
+	setJSON     stringSlice
+
+	pipeline        string
+	valuesPerEnv    bool
+	manifests       string
+	name            string
+	force           bool
+	dryRun          bool
+	summary         string
+	kubeVersion     string
+	apiVersions     stringSlice
+	check           bool
+	schema          string
+	failOn          string
+	bump            bool
+	commit          string
+	previous        string
+	createNamespace bool
+	version         string
+	timeout         time.Duration
+	wait            bool
+	waitForJobs     bool
+	atomic          bool
+	forceUpdate     bool
+	recreatePods    bool
+	resetValues     bool
+	reuseValues     bool
+	skipCRDs        bool
+	noHooks         bool
+	description     string
+	labels          string
+	recoverPolicy   string
+	depUpdate       bool
+	offline         bool
+	strict          bool
+	max             int
+}
+
+// stringSlice is a flag.Value which may be repeated
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func newOptions(c *command) *options {
+	o := &options{flags: flag.NewFlagSet(c.name, flag.ContinueOnError)}
+	fs := o.flags
+	fs.StringVar(&o.specFile, "spec", "", "spec file or directory describing the apps of the chart, - reads stdin")
+	fs.StringVar(&o.outputDir, "output-dir", "", "directory the chart is generated or packaged into (default: the spec path or .)")
+	fs.StringVar(&o.namespace, "namespace", "", "namespace scope for this request")
+	fs.StringVar(&o.namespace, "n", "", "shorthand for --namespace")
+	fs.StringVar(&o.kubeContext, "kube-context", "", "name of the kubeconfig context to use")
+	fs.StringVar(&o.kubeConfig, "kubeconfig", "", "path to the kubeconfig file")
+	fs.Var(&o.valueFiles, "values", "values file, may be repeated")
+	fs.Var(&o.valueFiles, "f", "shorthand for --values")
+	if c.flags != nil {
+		c.flags(o)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: helm-maker %s [flags] %s\n\n%s\n\nFlags:\n", c.name, c.args, c.short)
+		fs.PrintDefaults()
+	}
+	return o
+}
+
+// helm builds the helm client from the kube flags
+func (o *options) helm() (*chart.Helm, error) {
+	return chart.NewHelm(
+		chart.WithEnvFunc(func(settings *cli.EnvSettings) {
+			if o.kubeContext != "" {
+				settings.KubeContext = o.kubeContext
+			}
+			if o.kubeConfig != "" {
+				settings.KubeConfig = o.kubeConfig
+			}
+			if o.namespace != "" {
+				settings.SetNamespace(o.namespace)
+			}
+		}),
+		chart.WithValueFiles(o.valueFiles...),
+		chart.WithLogger(func(format string, args ...interface{}) {}),
+	)
+}
+
+// exactArgs checks the number of positional arguments
+func exactArgs(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%w: expected %d argument(s), got %d", errUsage, n, len(args))
+	}
+	return nil
+}
+
+// rangeArgs checks the number of positional arguments is between min and max
+func rangeArgs(args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("%w: expected %d to %d argument(s), got %d", errUsage, min, max, len(args))
+	}
+	return nil
+}
+
+// parseInterspersed parses flags given before, between and after the positional
+// arguments, the arguments after -- are all positional
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "helm-maker create and deploy helm charts by data")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage: helm-maker <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'helm-maker <command> -h' for the flags of a command.")
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stdout)
+		return exitOK
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return exitUsage
+	}
+	o := newOptions(c)
+	positional, err := parseInterspersed(o.flags, args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := c.run(o, positional); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if errors.Is(err, errUsage) {
+			o.flags.Usage()
+			return exitUsage
+		}
+		if errors.Is(err, errDrift) {
+			return exitDrift
+		}
+		return exitError
+	}
+	return exitOK
+}
+
 func main() {
-	apps := chart.InitApps()
-	result, err := chart.ChartsFile(apps)
-	fmt.Println(result, err)
+	os.Exit(run(os.Args[1:]))
 }
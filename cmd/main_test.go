@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"helm-maker/chart"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	for _, tc := range []struct {
+		args       []string
+		positional []string
+		name       string
+		force      bool
+	}{
+		{args: nil},
+		{args: []string{"a", "b"}, positional: []string{"a", "b"}},
+		{args: []string{"--name", "x", "a"}, positional: []string{"a"}, name: "x"},
+		{args: []string{"a", "--name", "x", "b", "--force"}, positional: []string{"a", "b"}, name: "x", force: true},
+		{args: []string{"a", "--name=x"}, positional: []string{"a"}, name: "x"},
+		{args: []string{"--", "--a", "--b"}, positional: []string{"--a", "--b"}},
+		{args: []string{"a", "--force", "--", "b", "--name", "x"}, positional: []string{"a", "b", "--name", "x"}, force: true},
+		{args: []string{"a", "--"}, positional: []string{"a"}},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		name := fs.String("name", "", "")
+		force := fs.Bool("force", false, "")
+		positional, err := parseInterspersed(fs, tc.args)
+		if err != nil {
+			t.Errorf("%q: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(positional, tc.positional) || *name != tc.name || *force != tc.force {
+			t.Errorf("%q: got %q --name=%q --force=%v", tc.args, positional, *name, *force)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	if _, err := parseInterspersed(fs, []string{"a", "--unknown"}); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	stdout = ioutil.Discard
+	spec := filepath.Join("..", "test", "spec-web", "apps.yaml")
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{args: nil, code: exitUsage},
+		{args: []string{"help"}, code: exitOK},
+		{args: []string{"unknown"}, code: exitUsage},
+		{args: []string{"generate", "-h"}, code: exitOK},
+		{args: []string{"generate", "--unknown"}, code: exitUsage},
+		{args: []string{"generate", "extra"}, code: exitUsage},
+		{args: []string{"generate", "--commit", "abc"}, code: exitUsage},
+		{args: []string{"lint", filepath.Join(dir, "missing")}, code: exitError},
+		// the chart is not generated yet
+		{args: []string{"generate", "--spec", spec, "--output-dir", dir, "--dry-run"}, code: exitDrift},
+		{args: []string{"generate", "--spec", spec, "--output-dir", dir}, code: exitOK},
+		{args: []string{"generate", "--spec", spec, "--output-dir", dir, "--dry-run"}, code: exitOK},
+	} {
+		if code := run(tc.args); code != tc.code {
+			t.Errorf("%q: exit code %d, expected %d", tc.args, code, tc.code)
+		}
+	}
+}
+
+func TestReleaseLabels(t *testing.T) {
+	for _, tc := range []struct {
+		labels   string
+		expected map[string]string
+		err      bool
+	}{
+		{labels: ""},
+		{labels: "team=shop", expected: map[string]string{"team": "shop"}},
+		{labels: " team = shop ,tier=", expected: map[string]string{"team": "shop", "tier": ""}},
+		{labels: "a=b=c", expected: map[string]string{"a": "b=c"}},
+		{labels: "team", err: true},
+		{labels: "=shop", err: true},
+		{labels: "team=shop,", err: true},
+	} {
+		o := &options{labels: tc.labels}
+		labels, err := o.releaseLabels()
+		if tc.err {
+			if !errors.Is(err, errUsage) {
+				t.Errorf("%q: expected a usage error, got %v", tc.labels, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(labels, tc.expected) {
+			t.Errorf("%q: got %v %v", tc.labels, labels, err)
+		}
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	for _, tc := range []struct {
+		failOn string
+		strict bool
+		sev    chart.Severity
+		err    bool
+	}{
+		{failOn: "error", sev: chart.SeverityError},
+		{failOn: "info", sev: chart.SeverityInfo},
+		{failOn: "error", strict: true, sev: chart.SeverityWarning},
+		{failOn: "info", strict: true, sev: chart.SeverityInfo},
+		{failOn: "fatal", err: true},
+	} {
+		o := &options{failOn: tc.failOn, strict: tc.strict, kubeVersion: "v1.22.0"}
+		opts, err := o.checkOptions()
+		if tc.err {
+			if !errors.Is(err, errUsage) {
+				t.Errorf("%s: expected a usage error, got %v", tc.failOn, err)
+			}
+			continue
+		}
+		if err != nil || opts.FailOn != tc.sev || opts.KubeVersion != "v1.22.0" {
+			t.Errorf("%s strict=%v: got %+v %v", tc.failOn, tc.strict, opts, err)
+		}
+	}
+}
+
+func TestBumpOptions(t *testing.T) {
+	for _, tc := range []struct {
+		o    options
+		opts int
+		err  bool
+	}{
+		{o: options{}},
+		{o: options{bump: true}, opts: 1},
+		{o: options{bump: true, commit: "32cbee7"}, opts: 1},
+		{o: options{commit: "32cbee7"}, err: true},
+		{o: options{previous: "index.yaml"}, err: true},
+	} {
+		opts, err := tc.o.bumpOptions(chart.InitApps())
+		if tc.err {
+			if !errors.Is(err, errUsage) {
+				t.Errorf("%+v: expected a usage error, got %v", tc.o, err)
+			}
+			continue
+		}
+		if err != nil || len(opts) != tc.opts {
+			t.Errorf("%+v: got %d options %v", tc.o, len(opts), err)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"helm-maker/chart"
+
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func init() {
+	register(&command{
+		name:  "install",
+		args:  "RELEASE CHART",
+		short: "install a chart as a release",
+		flags: func(o *options) {
+			o.flags.BoolVar(&o.createNamespace, "create-namespace", false, "create the release namespace if not present")
+			o.valueFlags()
+			o.releaseFlags()
+			o.flags.BoolVar(&o.atomic, "atomic", false, "uninstall the release when the install fails, sets --wait")
+		},
+		run: runInstall,
+	})
+	register(&command{
+		name:  "upgrade",
+		args:  "RELEASE CHART",
+		short: "upgrade a release to a new version of a chart",
+		flags: func(o *options) {
+			o.valueFlags()
+			o.releaseFlags()
+			o.flags.BoolVar(&o.atomic, "atomic", false, "roll the release back when the upgrade fails, sets --wait")
+			o.flags.BoolVar(&o.forceUpdate, "force", false, "force resource updates through a replacement strategy")
+			o.flags.BoolVar(&o.recreatePods, "recreate-pods", false, "restart the pods of the release")
+			o.flags.BoolVar(&o.resetValues, "reset-values", false, "reset the values to the ones of the chart")
+			o.flags.BoolVar(&o.reuseValues, "reuse-values", false, "merge the values over the ones of the last release, ignored with --reset-values")
+		},
+		run: runUpgrade,
+	})
+	register(&command{
+		name:  "apply",
+		args:  "RELEASE CHART",
+		short: "install a chart as a release, or upgrade the release when it is installed",
+		flags: func(o *options) {
+			o.flags.BoolVar(&o.createNamespace, "create-namespace", false, "create the release namespace if not present")
+			o.valueFlags()
+			o.releaseFlags()
+			o.flags.BoolVar(&o.atomic, "atomic", false, "uninstall the release when the install fails, roll it back when the upgrade fails, sets --wait")
+			o.flags.BoolVar(&o.forceUpdate, "force", false, "force resource updates through a replacement strategy")
+			o.flags.BoolVar(&o.recreatePods, "recreate-pods", false, "restart the pods of the release")
+			o.flags.BoolVar(&o.resetValues, "reset-values", false, "reset the values to the ones of the chart")
+			o.flags.BoolVar(&o.reuseValues, "reuse-values", false, "merge the values over the ones of the last release, ignored with --reset-values")
+			o.flags.StringVar(&o.recoverPolicy, "recover", "none", "how a release stuck in a pending state or whose first install failed is recovered: none, rollback or reinstall")
+		},
+		run: runApply,
+	})
+	register(&command{
+		name:  "rollback",
+		args:  "RELEASE [REVISION]",
+		short: "roll back a release to a revision, the previous one by default",
+		flags: func(o *options) {
+			o.waitFlags()
+			o.flags.BoolVar(&o.dryRun, "dry-run", false, "simulate a rollback")
+			o.flags.BoolVar(&o.forceUpdate, "force", false, "force resource updates through a replacement strategy")
+			o.flags.BoolVar(&o.recreatePods, "recreate-pods", false, "restart the pods of the release")
+			o.flags.BoolVar(&o.noHooks, "no-hooks", false, "prevent hooks from running during rollback")
+		},
+		run: runRollback,
+	})
+	register(&command{
+		name:  "status",
+		args:  "RELEASE",
+		short: "display the status of a release",
+		run:   runStatus,
+	})
+	register(&command{
+		name:  "history",
+		args:  "RELEASE",
+		short: "print the revisions of a release",
+		flags: func(o *options) {
+			o.flags.IntVar(&o.max, "max", 256, "maximum number of revisions to include")
+		},
+		run: runHistory,
+	})
+	register(&command{
+		name:  "uninstall",
+		args:  "RELEASE",
+		short: "uninstall a release",
+		run:   runUninstall,
+	})
+}
+
+// valueFlags adds the flags setting values of a release, like helm
+func (o *options) valueFlags() {
+	o.flags.Var(&o.setValues, "set", "set values like key1=val1,key2.sub[0]=val2, may be repeated")
+	o.flags.Var(&o.setStrings, "set-string", "set STRING values like key1=val1,key2=val2, may be repeated")
+	o.flags.Var(&o.setFiles, "set-file", "set values from the content of files like key1=path1, may be repeated")
+	o.flags.Var(&o.setJSON, "set-json", "set a JSON value like key1='{\"a\":1}', may be repeated")
+}
+
+// waitFlags adds the flags of waiting for a release
+func (o *options) waitFlags() {
+	o.flags.DurationVar(&o.timeout, "timeout", chart.DefaultTimeout, "time to wait for any individual Kubernetes operation (like Jobs for hooks)")
+	o.flags.BoolVar(&o.wait, "wait", false, "wait until the resources of the release are ready, as long as --timeout")
+	o.flags.BoolVar(&o.waitForJobs, "wait-for-jobs", false, "with --wait, wait until the jobs of the release are complete too")
+}
+
+// releaseFlags adds the flags shared by install and upgrade
+func (o *options) releaseFlags() {
+	o.waitFlags()
+	o.flags.StringVar(&o.version, "version", "", "version constraint of a chart of a repository (default: the latest version, prereleases included)")
+	o.flags.BoolVar(&o.dryRun, "dry-run", false, "simulate the release against the cluster and print its manifest")
+	o.flags.BoolVar(&o.skipCRDs, "skip-crds", false, "install no CRDs of the crds directory of the chart")
+	o.flags.BoolVar(&o.noHooks, "no-hooks", false, "prevent hooks from running")
+	o.flags.StringVar(&o.description, "description", "", "custom description of the release")
+	o.flags.StringVar(&o.labels, "labels", "", "labels added to every object of the release, like key1=val1,key2=val2, except app.kubernetes.io/managed-by")
+	o.dependencyFlags()
+}
+
+// dependencyFlags adds the flags of resolving the dependencies of a chart
+func (o *options) dependencyFlags() {
+	o.flags.BoolVar(&o.depUpdate, "dependency-update", false, "update the dependencies of the chart and Chart.lock, even when they are in its charts directory")
+	o.flags.BoolVar(&o.offline, "offline", false, "resolve the missing dependencies from the cached repository indexes and chart archives only")
+}
+
+// dependencyOptions are the options of resolving dependencies from the flags
+func (o *options) dependencyOptions() chart.DependencyOptions {
+	return chart.DependencyOptions{Update: o.depUpdate, Offline: o.offline}
+}
+
+// releaseLabels parses --labels
+func (o *options) releaseLabels() (map[string]string, error) {
+	if o.labels == "" {
+		return nil, nil
+	}
+	labels := map[string]string{}
+	for _, kv := range strings.Split(o.labels, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: --labels: %q is not key=value", errUsage, kv)
+		}
+		labels[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return labels, nil
+}
+
+// valueOptions are the values of a release from the flags, the values files
+// of --values are the ones of the client
+func (o *options) valueOptions() chart.ValueOptions {
+	return chart.ValueOptions{
+		Values:       o.setValues,
+		StringValues: o.setStrings,
+		FileValues:   o.setFiles,
+		JSONValues:   o.setJSON,
+	}
+}
+
+// printRelease prints a release like helm, with its manifest after a dry run
+func printRelease(rel *release.Release, manifest bool) {
+	fmt.Fprintf(stdout, "NAME: %s\n", rel.Name)
+	if rel.Info != nil {
+		fmt.Fprintf(stdout, "LAST DEPLOYED: %s\n", rel.Info.LastDeployed.Format("Mon Jan _2 15:04:05 2006"))
+	}
+	fmt.Fprintf(stdout, "NAMESPACE: %s\n", rel.Namespace)
+	if rel.Info != nil {
+		fmt.Fprintf(stdout, "STATUS: %s\n", rel.Info.Status)
+	}
+	fmt.Fprintf(stdout, "REVISION: %d\n", rel.Version)
+	if manifest {
+		fmt.Fprintf(stdout, "MANIFEST:\n%s\n", strings.TrimSpace(rel.Manifest))
+	}
+	if rel.Info != nil && rel.Info.Notes != "" {
+		fmt.Fprintf(stdout, "NOTES:\n%s\n", rel.Info.Notes)
+	}
+}
+
+func runInstall(o *options, args []string) error {
+	if err := exactArgs(args, 2); err != nil {
+		return err
+	}
+	labels, err := o.releaseLabels()
+	if err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	rel, err := h.Install(o.namespace, args[1], args[0], chart.InstallOptions{
+		ValueOptions:    o.valueOptions(),
+		CreateNamespace: o.createNamespace,
+		Version:         o.version,
+		Timeout:         o.timeout,
+		Wait:            o.wait,
+		WaitForJobs:     o.waitForJobs,
+		Atomic:          o.atomic,
+		DryRun:          o.dryRun,
+		SkipCRDs:        o.skipCRDs,
+		DisableHooks:    o.noHooks,
+		Description:     o.description,
+		Labels:          labels,
+		Dependencies:    o.dependencyOptions(),
+	})
+	if err != nil {
+		return err
+	}
+	printRelease(rel, o.dryRun)
+	return nil
+}
+
+func runUpgrade(o *options, args []string) error {
+	if err := exactArgs(args, 2); err != nil {
+		return err
+	}
+	labels, err := o.releaseLabels()
+	if err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	rel, err := h.Upgrade(o.namespace, args[1], args[0], chart.UpgradeOptions{
+		ValueOptions: o.valueOptions(),
+		Version:      o.version,
+		Timeout:      o.timeout,
+		Wait:         o.wait,
+		WaitForJobs:  o.waitForJobs,
+		Atomic:       o.atomic,
+		DryRun:       o.dryRun,
+		Force:        o.forceUpdate,
+		Recreate:     o.recreatePods,
+		ResetValues:  o.resetValues,
+		ReuseValues:  o.reuseValues,
+		SkipCRDs:     o.skipCRDs,
+		DisableHooks: o.noHooks,
+		Description:  o.description,
+		Labels:       labels,
+		Dependencies: o.dependencyOptions(),
+	})
+	if err != nil {
+		return err
+	}
+	printRelease(rel, o.dryRun)
+	return nil
+}
+
+func runApply(o *options, args []string) error {
+	if err := exactArgs(args, 2); err != nil {
+		return err
+	}
+	policy, err := chart.ParseRecoverPolicy(o.recoverPolicy)
+	if err != nil {
+		return fmt.Errorf("%w: --recover: %v", errUsage, err)
+	}
+	labels, err := o.releaseLabels()
+	if err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	r, err := h.Apply(o.namespace, args[1], args[0], chart.ApplyOptions{
+		UpgradeOptions: chart.UpgradeOptions{
+			ValueOptions: o.valueOptions(),
+			Version:      o.version,
+			Timeout:      o.timeout,
+			Wait:         o.wait,
+			WaitForJobs:  o.waitForJobs,
+			Atomic:       o.atomic,
+			DryRun:       o.dryRun,
+			Force:        o.forceUpdate,
+			Recreate:     o.recreatePods,
+			ResetValues:  o.resetValues,
+			ReuseValues:  o.reuseValues,
+			SkipCRDs:     o.skipCRDs,
+			DisableHooks: o.noHooks,
+			Description:  o.description,
+			Labels:       labels,
+			Dependencies: o.dependencyOptions(),
+		},
+		CreateNamespace: o.createNamespace,
+		Recover:         policy,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(stdout, r)
+	printRelease(r.Release, o.dryRun)
+	return nil
+}
+
+func runRollback(o *options, args []string) error {
+	if err := rangeArgs(args, 1, 2); err != nil {
+		return err
+	}
+	var revision int
+	if len(args) == 2 {
+		var err error
+		if revision, err = strconv.Atoi(args[1]); err != nil || revision < 1 {
+			return fmt.Errorf("%w: invalid revision %q", errUsage, args[1])
+		}
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	err = h.Rollback(o.namespace, args[0], chart.RollbackOptions{
+		Revision:     revision,
+		Timeout:      o.timeout,
+		Wait:         o.wait,
+		WaitForJobs:  o.waitForJobs,
+		DryRun:       o.dryRun,
+		Force:        o.forceUpdate,
+		Recreate:     o.recreatePods,
+		DisableHooks: o.noHooks,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Rollback was a success!")
+	return nil
+}
+
+func runStatus(o *options, args []string) error {
+	if err := exactArgs(args, 1); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	rel, err := h.Status(o.namespace, args[0])
+	if err != nil {
+		return err
+	}
+	printRelease(rel, false)
+	return nil
+}
+
+func runHistory(o *options, args []string) error {
+	if err := exactArgs(args, 1); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	hist, err := h.History(o.namespace, args[0], o.max)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tUPDATED\tSTATUS\tCHART\tAPP VERSION\tDESCRIPTION")
+	for _, rel := range hist {
+		var updated, status, description string
+		if rel.Info != nil {
+			updated = rel.Info.LastDeployed.Format("Mon Jan _2 15:04:05 2006")
+			status = rel.Info.Status.String()
+			description = rel.Info.Description
+		}
+		var chrt, appVersion string
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			chrt = rel.Chart.Metadata.Name + "-" + rel.Chart.Metadata.Version
+			appVersion = rel.Chart.Metadata.AppVersion
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", rel.Version, updated, status, chrt, appVersion, description)
+	}
+	return w.Flush()
+}
+
+func runUninstall(o *options, args []string) error {
+	if err := exactArgs(args, 1); err != nil {
+		return err
+	}
+	h, err := o.helm()
+	if err != nil {
+		return err
+	}
+	res, err := h.Uninstall(o.namespace, args[0])
+	if err != nil {
+		return err
+	}
+	if res != nil && res.Info != "" {
+		fmt.Fprintln(stdout, res.Info)
+	}
+	fmt.Fprintf(stdout, "release \"%s\" uninstalled\n", args[0])
+	return nil
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"errors"
+	"helm-maker/chart"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// fakeHelm is a helm client whose releases are kept in memory and whose
+// cluster accepts every object
+func fakeHelm(t *testing.T, opts ...chart.HelmOpt) (*chart.Helm, *action.Configuration) {
+	t.Helper()
+	config := &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+	opts = append([]chart.HelmOpt{chart.WithActionConfig(config), chart.WithLogger(func(string, ...interface{}) {})}, opts...)
+	h, err := chart.NewHelm(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, config
+}
+
+// setStatus stores a new revision of the last release with the status
+func setStatus(t *testing.T, config *action.Configuration, name string, status release.Status) {
+	t.Helper()
+	last, err := config.Releases.Last(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := *last
+	info := *last.Info
+	info.Status = status
+	rel.Info = &info
+	rel.Version++
+	if err := config.Releases.Create(&rel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// updateStatus sets the status of the last revision of the release
+func updateStatus(t *testing.T, config *action.Configuration, name string, status release.Status) {
+	t.Helper()
+	last, err := config.Releases.Last(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last.Info.Status = status
+	if err := config.Releases.Update(last); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	if _, err := chart.Generate(apps); err != nil {
+		t.Fatal(err)
+	}
+	cdir := filepath.Join(apps.Path, "web")
+	h, config := fakeHelm(t)
+	apply := func(policy chart.RecoverPolicy, action, recovered chart.ApplyAction, revision int) {
+		t.Helper()
+		r, err := h.Apply("default", cdir, "web", chart.ApplyOptions{Recover: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Action != action || r.Recovered != recovered || r.Release.Version != revision || r.Release.Info.Status != release.StatusDeployed {
+			t.Fatalf("expected %s after %q at revision %d, got %s at revision %d", action, recovered, revision, r, r.Release.Version)
+		}
+	}
+	apply(chart.RecoverNone, chart.ApplyInstalled, "", 1)
+	apply(chart.RecoverNone, chart.ApplyUpgraded, "", 2)
+
+	// a deploy killed during an upgrade
+	setStatus(t, config, "web", release.StatusPendingUpgrade)
+	if _, err := h.Apply("default", cdir, "web", chart.ApplyOptions{}); !errors.Is(err, chart.ErrPending) {
+		t.Fatalf("expected ErrPending, got %v", err)
+	}
+	apply(chart.RecoverRollback, chart.ApplyUpgraded, chart.ApplyRolledBack, 5)
+	if rel, err := config.Releases.Get("web", 4); err != nil || rel.Info.Description != "Rollback to 2" {
+		t.Fatalf("expected a rollback to revision 2, got %+v: %v", rel, err)
+	}
+
+	// a deploy killed during the first install
+	if _, err := h.Uninstall("default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	apply(chart.RecoverNone, chart.ApplyInstalled, "", 1)
+	updateStatus(t, config, "web", release.StatusPendingInstall)
+	apply(chart.RecoverRollback, chart.ApplyInstalled, chart.ApplyUninstalled, 1)
+
+	// a failed first install is upgraded, like helm does, unless it is recovered
+	updateStatus(t, config, "web", release.StatusFailed)
+	apply(chart.RecoverReinstall, chart.ApplyInstalled, chart.ApplyUninstalled, 1)
+	updateStatus(t, config, "web", release.StatusFailed)
+	apply(chart.RecoverNone, chart.ApplyUpgraded, "", 2)
+
+	// a release uninstalled with its history kept is installed again
+	uninstall := action.NewUninstall(config)
+	uninstall.KeepHistory = true
+	if _, err := uninstall.Run("web"); err != nil {
+		t.Fatal(err)
+	}
+	apply(chart.RecoverNone, chart.ApplyInstalled, "", 3)
+
+	if _, err := chart.ParseRecoverPolicy("retry"); err == nil {
+		t.Fatal("expected an unknown recover policy")
+	}
+}
+
+func TestDeployApps(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = filepath.Join(t.TempDir(), "charts")
+	h, _ := fakeHelm(t)
+	rel, err := h.InstallApps("default", apps, "web", chart.InstallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != 1 || !strings.Contains(rel.Manifest, "# Source: web/templates/deployment_worker.yaml") {
+		t.Fatalf("unexpected release %d:\n%s", rel.Version, rel.Manifest)
+	}
+
+	apps.Sets[1].Values["value"].(map[string]interface{})["replicaCount"] = 4
+	if rel, err = h.UpgradeApps("default", apps, "web", chart.UpgradeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != 2 || !strings.Contains(rel.Manifest, "replicas: 4") {
+		t.Fatalf("unexpected release %d:\n%s", rel.Version, rel.Manifest)
+	}
+
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := h.ApplyChart("default", c, "web", chart.ApplyOptions{})
+	if err != nil || r.Action != chart.ApplyUpgraded || r.Release.Version != 3 {
+		t.Fatalf("unexpected result %+v: %v", r, err)
+	}
+	if r, err = h.ApplyApps("default", apps, "api", chart.ApplyOptions{}); err != nil || r.Action != chart.ApplyInstalled {
+		t.Fatalf("unexpected result %+v: %v", r, err)
+	}
+	if _, err := os.Stat(apps.Path); !os.IsNotExist(err) {
+		t.Fatalf("the chart was written to %s", apps.Path)
+	}
+}
+
+func TestDeployChartWithoutMetadata(t *testing.T) {
+	h, _ := fakeHelm(t)
+	c := &helmchart.Chart{}
+	if _, err := h.InstallChart("default", c, "web", chart.InstallOptions{}); err == nil || err.Error() != "chart has no metadata" {
+		t.Errorf("unexpected install error %v", err)
+	}
+	if _, err := h.UpgradeChart("default", c, "web", chart.UpgradeOptions{}); err == nil || err.Error() != "chart has no metadata" {
+		t.Errorf("unexpected upgrade error %v", err)
+	}
+	if _, err := h.ApplyChart("default", c, "web", chart.ApplyOptions{}); err == nil || err.Error() != "chart has no metadata" {
+		t.Errorf("unexpected apply error %v", err)
+	}
+	if _, err := h.RenderChart(c, chart.RenderOptions{}); err == nil || err.Error() != "chart has no metadata" {
+		t.Errorf("unexpected render error %v", err)
+	}
+}
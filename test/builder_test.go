@@ -0,0 +1,166 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+func TestBuildChart(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "web" || c.Metadata.Version != "0.1.0" {
+		t.Fatalf("unexpected metadata %+v", c.Metadata)
+	}
+	if _, ok := c.Values["worker"]; !ok {
+		t.Fatalf("values have no worker: %v", c.Values)
+	}
+	vals, err := chartutil.ToRenderValues(c, nil, chartutil.ReleaseOptions{Name: "r", Namespace: "default"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := engine.Render(c, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(files["web/templates/ingress_web.yaml"], "kind: Ingress") {
+		t.Errorf("ingress of web is not rendered: %v", files)
+	}
+
+	// unknown template kinds are errors, not skipped
+	apps.Sets[1].Types = append(apps.Sets[1].Types, "deploy")
+	if _, err := chart.BuildChart(apps); err == nil || !strings.Contains(err.Error(), `"deploy"`) {
+		t.Fatalf("expected an unknown kind error, got %v", err)
+	}
+}
+
+func TestSaveChart(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cdir := filepath.Join(dir, "web")
+	// an existing chart with a stale generated template and a hand written one
+	if err := os.MkdirAll(filepath.Join(cdir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cdir, "templates", "svc_web.yaml"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cdir, "templates", "extra.yaml"), []byte("# mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chart.SaveChart(c, dir); err != nil {
+		t.Fatal(err)
+	}
+	// svc_web.yaml was not generated by helm-maker, it is only overwritten by force
+	svc, err := ioutil.ReadFile(filepath.Join(cdir, "templates", "svc_web.yaml"))
+	if err != nil || string(svc) != "stale" {
+		t.Fatalf("svc_web.yaml was overwritten: %s %v", svc, err)
+	}
+	if _, err := chart.SaveChart(c, dir, chart.WithForce()); err != nil {
+		t.Fatal(err)
+	}
+	svc, err = ioutil.ReadFile(filepath.Join(cdir, "templates", "svc_web.yaml"))
+	if err != nil || !strings.Contains(string(svc), "kind: Service") {
+		t.Fatalf("svc_web.yaml was not regenerated: %s %v", svc, err)
+	}
+	if extra, err := ioutil.ReadFile(filepath.Join(cdir, "templates", "extra.yaml")); err != nil || string(extra) != "# mine\n" {
+		t.Fatalf("extra.yaml was not kept: %s %v", extra, err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary directories are left behind: %v", entries)
+	}
+
+	saved, err := loader.Load(cdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Templates) != len(c.Templates)+1 {
+		t.Fatalf("saved chart has %d templates, expected %d", len(saved.Templates), len(c.Templates)+1)
+	}
+
+	archive, err := chart.PackageChart(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(archive) != "web-0.1.0.tgz" {
+		t.Fatalf("unexpected archive %s", archive)
+	}
+	if _, err := loader.Load(archive); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateReport(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	r, err := chart.Generate(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count(chart.FileCreated) != len(r.Files) || r.Count(chart.FileCreated) == 0 {
+		t.Fatalf("expected only created files:\n%s", r)
+	}
+	for _, f := range r.Files {
+		if f.Path == "templates/hpa_web.yaml" && (f.App != "web" || f.Kind != "hpa") {
+			t.Errorf("unexpected origin of %s: %q %q", f.Path, f.App, f.Kind)
+		}
+	}
+
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 3
+	r, err = chart.Generate(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count(chart.FileOverwritten) != 1 || r.Count(chart.FileSkipped) != len(r.Files)-1 {
+		t.Fatalf("expected only values.yaml to be overwritten:\n%s", r)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	apps.Sets[0].Types = append(apps.Sets[0].Types, "deploy")
+	apps.Sets[1].Types = append(apps.Sets[1].Types, "stateful")
+	_, err = chart.Generate(apps)
+	errs, ok := err.(chart.GenerateErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected the errors of both apps, got %v", err)
+	}
+	if errs[0].App != "web" || errs[0].Kind != "deploy" || errs[1].App != "worker" || errs[1].Kind != "stateful" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if entries, _ := ioutil.ReadDir(apps.Path); len(entries) != 0 {
+		t.Fatalf("a failed generation left files behind: %v", entries)
+	}
+}
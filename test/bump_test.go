@@ -0,0 +1,198 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func TestBumpVersion(t *testing.T) {
+	for _, tc := range []struct {
+		previous, current string
+		bump              chart.Bump
+		prerelease        string
+		expected          string
+	}{
+		{"1.2.3", "0.1.0", chart.BumpNone, "", "1.2.3"},
+		{"1.2.3", "0.1.0", chart.BumpPatch, "", "1.2.4"},
+		{"1.2.3", "0.1.0", chart.BumpMinor, "", "1.3.0"},
+		{"1.2.3", "0.1.0", chart.BumpMajor, "", "2.0.0"},
+		{"1.2.3", "0.1.0", chart.BumpPatch, "g32cbee7", "1.2.4-1.g32cbee7"},
+		{"1.2.4-1.g32cbee7", "0.1.0", chart.BumpPatch, "g4a1b2c3", "1.2.4-2.g4a1b2c3"},
+		// the commits are not ordered, g0abc sorts before g32cbee7
+		{"1.2.4-9.g32cbee7", "0.1.0", chart.BumpPatch, "g0abc", "1.2.4-10.g0abc"},
+		{"1.2.4-1.g32cbee7", "0.1.0", chart.BumpPatch, "", "1.2.4"},
+		{"1.2.4-1.g32cbee7", "0.1.0", chart.BumpMinor, "g4a1b2c3", "1.3.0-1.g4a1b2c3"},
+		{"1.3.0-1.g32cbee7", "0.1.0", chart.BumpMinor, "", "1.3.0"},
+		{"1.2.4-1.g32cbee7", "0.1.0", chart.BumpMinor, "", "1.3.0"},
+		// a prerelease without a build number can't be ordered
+		{"1.2.4-g32cbee7", "0.1.0", chart.BumpPatch, "g0abc", "1.2.5-1.g0abc"},
+		{"1.2.4-g32cbee7", "0.1.0", chart.BumpPatch, "", "1.2.4"},
+		{"1.2.3", "3.0.0", chart.BumpPatch, "", "3.0.0"},
+	} {
+		prev, c := bumpCharts(tc.previous, tc.current, tc.bump)
+		change, err := chart.BumpVersion(c, prev, tc.prerelease)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change.Version != tc.expected || c.Metadata.Version != tc.expected || change.Bump != tc.bump {
+			t.Errorf("%s %s: expected %s, got %+v", tc.previous, tc.bump, tc.expected, change)
+		}
+		if tc.bump != chart.BumpNone && !semver.MustParse(change.Version).GreaterThan(semver.MustParse(tc.previous)) {
+			t.Errorf("%s %s: %s is not higher", tc.previous, tc.bump, change.Version)
+		}
+	}
+
+	c := &helmchart.Chart{Metadata: &helmchart.Metadata{Name: "web", Version: "0.1.0"}}
+	if change, err := chart.BumpVersion(c, nil, chart.Prerelease("32cbee7e294a5f0e")); err != nil || change.Version != "0.1.0-1.g32cbee7e294a" {
+		t.Fatalf("unexpected first version %+v: %v", change, err)
+	}
+}
+
+// bumpCharts returns charts of the versions whose values changed by bump
+func bumpCharts(previous, current string, bump chart.Bump) (*helmchart.Chart, *helmchart.Chart) {
+	prev := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "web", Version: previous},
+		Values:   map[string]interface{}{"web": map[string]interface{}{"replicaCount": 1}},
+	}
+	values := map[string]interface{}{"replicaCount": 1}
+	switch bump {
+	case chart.BumpPatch:
+		values["replicaCount"] = 2
+	case chart.BumpMinor:
+		values["image"] = "nginx"
+	case chart.BumpMajor:
+		delete(values, "replicaCount")
+	}
+	c := &helmchart.Chart{
+		Metadata: &helmchart.Metadata{Name: "web", Version: current},
+		Values:   map[string]interface{}{"web": values},
+	}
+	if bump == chart.BumpMinor {
+		c.Values["api"] = map[string]interface{}{}
+	}
+	return prev, c
+}
+
+func TestCompareCharts(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bump, reasons := chart.CompareCharts(prev, prev); bump != chart.BumpNone || len(reasons) != 0 {
+		t.Fatalf("expected no change, got %s %v", bump, reasons)
+	}
+
+	value := apps.Sets[0].Values["value"].(map[string]interface{})
+	value["replicaCount"] = 3
+	delete(value, "resources")
+	apps.Sets[1].Types = []string{"deployment", "svc"}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bump, reasons := chart.CompareCharts(prev, c)
+	if bump != chart.BumpMajor {
+		t.Fatalf("expected a major bump, got %s %v", bump, reasons)
+	}
+	for _, r := range []string{
+		"value web.value.replicaCount changed",
+		"value web.value.resources removed",
+		"template templates/svc_worker.yaml added",
+	} {
+		if !strings.Contains(strings.Join(reasons, "\n"), r) {
+			t.Errorf("expected %q in %v", r, reasons)
+		}
+	}
+}
+
+func TestGenerateBump(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	generate := func(expected string, bump chart.Bump) {
+		t.Helper()
+		r, err := chart.Generate(apps, chart.WithBump(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Version.Version != expected || r.Version.Bump != bump {
+			t.Fatalf("expected %s by a %s bump, got %s", expected, bump, r.Version)
+		}
+	}
+	generate("0.1.0", chart.BumpNone)
+	generate("0.1.0", chart.BumpNone)
+
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 3
+	generate("0.1.1", chart.BumpPatch)
+	generate("0.1.1", chart.BumpNone)
+
+	apps.Sets = append(apps.Sets, &chart.App{
+		Name:   "api",
+		Types:  []string{"deployment"},
+		Values: map[string]interface{}{"appname": "api", "value": map[string]interface{}{"image": map[string]interface{}{"repository": "api"}}},
+	})
+	generate("0.2.0", chart.BumpMinor)
+
+	apps.Sets = apps.Sets[:1]
+	generate("1.0.0", chart.BumpMajor)
+}
+
+func TestLatestChart(t *testing.T) {
+	dir := t.TempDir()
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"0.1.0", "0.3.0-1.g32cbee7", "0.2.0"} {
+		apps.Version = version
+		c, err := chart.BuildChart(apps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := chart.PackageChart(c, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := repo.IndexDirectory(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(dir, "index.yaml")
+	if err := idx.WriteFile(index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := h.LatestChart(index, "web")
+	if err != nil || latest == nil || latest.Metadata.Version != "0.3.0-1.g32cbee7" {
+		t.Fatalf("unexpected latest chart %+v: %v", latest, err)
+	}
+	if c, err := h.LatestChart(index, "api"); err != nil || c != nil {
+		t.Fatalf("expected no chart api, got %+v: %v", c, err)
+	}
+
+	apps.Version = ""
+	apps.Path = t.TempDir()
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 3
+	r, err := chart.Generate(apps, chart.WithBump("g4a1b2c3"), chart.WithPrevious(latest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version.Previous != "0.3.0-1.g32cbee7" || r.Version.Version != "0.3.0-2.g4a1b2c3" {
+		t.Fatalf("unexpected version %s", r.Version)
+	}
+}
@@ -1,9 +1,15 @@
 package test
 
 import (
+	"bytes"
 	"fmt"
 	"helm-maker/chart"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
 )
 
 func TestGenLocalChart(t *testing.T) {
@@ -21,3 +27,56 @@ func TestGenChartsFile(t *testing.T) {
 	result, err := chart.ChartsFile(apps)
 	fmt.Println(result, err)
 }
+
+func TestGenLocalChartVersion(t *testing.T) {
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the previous chart is the one in the nested directory
+	dir := filepath.Join(t.TempDir(), "charts", "web")
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	version := func(opts ...chart.GenerateOpt) string {
+		t.Helper()
+		c, err := h.GenLocalChart(dir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved, err := chartutil.LoadChartfile(filepath.Join(dir, chart.ChartfileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Version != c.Metadata.Version {
+			t.Fatalf("saved version %s, returned %s", saved.Version, c.Metadata.Version)
+		}
+		return c.Metadata.Version
+	}
+	if v := version(); v != "0.1.0" {
+		t.Fatalf("unexpected first version %s", v)
+	}
+	if v := version(); v != "0.1.0" {
+		t.Fatalf("the unchanged chart is bumped to %s", v)
+	}
+	// the edited values are overwritten by the default ones
+	values := filepath.Join(dir, chart.ValuesfileName)
+	data, err := ioutil.ReadFile(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("replicaCount: 1"), []byte("replicaCount: 2"), 1)
+	if err := ioutil.WriteFile(values, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(chart.WithBump("g32cbee7")); v != "0.1.1-1.g32cbee7" {
+		t.Fatalf("unexpected bumped version %s", v)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, chart.ChartfileName), []byte("version: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.GenLocalChart(dir); err == nil {
+		t.Fatal("expected an error for the invalid previous chart")
+	}
+}
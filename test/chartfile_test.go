@@ -0,0 +1,101 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"strings"
+	"testing"
+)
+
+func TestChartMetadata(t *testing.T) {
+	spec := `
+name: shop
+version: 1.2.3
+metadata:
+  appVersion: "2.0"
+  description: The shop
+  kubeVersion: ">=1.21.0-0"
+  keywords: [shop, web]
+  home: https://shop.example.com
+  sources: [https://git.example.com/shop]
+  maintainers:
+    - {name: ops, email: ops@example.com}
+  icon: https://shop.example.com/icon.png
+  annotations: {category: commerce}
+apps:
+  - name: web
+    types: [deployment]
+    values:
+      appname: web
+      value:
+        image: {repository: nginx}
+`
+	apps, err := chart.ParseSpec("apps.yaml", []byte(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := c.Metadata
+	if md.Version != "1.2.3" || md.AppVersion != "2.0" || md.Description != "The shop" || md.Type != "application" ||
+		md.KubeVersion != ">=1.21.0-0" || strings.Join(md.Keywords, ",") != "shop,web" || md.Home != "https://shop.example.com" ||
+		len(md.Sources) != 1 || len(md.Maintainers) != 1 || md.Maintainers[0].Email != "ops@example.com" ||
+		md.Icon == "" || md.Annotations["category"] != "commerce" {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	var chartfile string
+	for _, f := range c.Raw {
+		if f.Name == chart.ChartfileName {
+			chartfile = string(f.Data)
+		}
+	}
+	if !inOrder(chartfile, "apiVersion: v2", "name: shop", "description: The shop", "type: application", "version: 1.2.3", `appVersion: "2.0"`) {
+		t.Fatalf("unexpected Chart.yaml:\n%s", chartfile)
+	}
+
+	apps.Metadata = nil
+	apps.Version = ""
+	if c, err = chart.BuildChart(apps); err != nil || c.Metadata.Version != "0.1.0" || c.Metadata.AppVersion != "1.16.0" {
+		t.Fatalf("unexpected default metadata %+v: %v", c, err)
+	}
+
+	for version, msg := range map[string]string{
+		"1.x":   `chart.metadata.version "1.x" is invalid`,
+		"1.0.0": `chart.metadata.kubeVersion "1.21 <" is invalid`,
+	} {
+		apps.Version = version
+		apps.Metadata = &chart.ChartMetadata{KubeVersion: "1.21 <"}
+		if _, err := chart.BuildChart(apps); err == nil || !strings.Contains(err.Error(), "Chart.yaml: validation: "+msg) {
+			t.Errorf("expected %q, got %v", msg, err)
+		}
+	}
+}
+
+func TestChartMetadataSpecErrors(t *testing.T) {
+	spec := `
+name: shop
+metadata:
+  type: app
+  keywords: shop
+  maintainers: [{email: ops@example.com}]
+  owner: ops
+apps:
+  - name: web
+    types: [deployment]
+`
+	_, err := chart.ParseSpec("apps.yaml", []byte(spec))
+	if err == nil {
+		t.Fatal("expected spec errors")
+	}
+	for _, msg := range []string{
+		`apps.yaml:4:9: metadata.type: unknown chart type "app", expected application or library`,
+		`apps.yaml:5:13: metadata.keywords: expected a list of strings, got str "shop"`,
+		`apps.yaml:6:17: metadata.maintainers[0].name: maintainer name is required`,
+		`apps.yaml:7:3: metadata.owner: unknown field`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in\n%v", msg, err)
+		}
+	}
+}
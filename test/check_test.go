@@ -0,0 +1,173 @@
+package test
+
+import (
+	"errors"
+	"helm-maker/chart"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	dir, err := chart.ChartsFile(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := h.Check(dir, chart.CheckOptions{FailOn: chart.SeverityWarning})
+	if err != nil {
+		t.Fatalf("the generated chart has problems: %v\n%s", err, r)
+	}
+	if r.KubeVersion != "v1.21.2" {
+		t.Errorf("unexpected kube version %s", r.KubeVersion)
+	}
+
+	extra := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: extra
+spec:
+  replicas: "2"
+  template:
+    spec:
+      containers:
+        - image: nginx
+          imagePullPolicy: Always
+          port: 80
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: extra
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: extra
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "templates", "extra.yaml"), []byte(extra), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = h.Check(dir, chart.CheckOptions{FailOn: chart.SeverityError})
+	if !errors.Is(err, chart.ErrCheckFailed) {
+		t.Fatalf("expected the check to fail, got %v", err)
+	}
+	found := map[string]chart.Severity{}
+	for _, p := range r.Problems {
+		// lint reports the missing selector too, without an object
+		if p.Path == "templates/extra.yaml" && p.Object != "" {
+			found[p.Object+" "+p.Field+": "+p.Message] = p.Severity
+		}
+	}
+	for problem, sev := range map[string]chart.Severity{
+		"Deployment/extra spec.replicas: expected an integer, got string \"2\"":                                                chart.SeverityError,
+		"Deployment/extra spec.selector: required field is missing":                                                            chart.SeverityError,
+		"Deployment/extra spec.template.spec.containers[0].name: required field is missing":                                    chart.SeverityError,
+		"Deployment/extra spec.template.spec.containers[0].port: unknown field":                                                chart.SeverityError,
+		"HorizontalPodAutoscaler/extra : autoscaling/v2 HorizontalPodAutoscaler is not served by Kubernetes v1.21.2":           chart.SeverityError,
+		"ServiceMonitor/extra : no schema of the custom resource monitoring.coreos.com/v1 ServiceMonitor, it is not validated": chart.SeverityInfo,
+	} {
+		if s, ok := found[problem]; !ok || s != sev {
+			t.Errorf("expected the %s problem %q, got\n%s", sev, problem, r)
+		}
+	}
+	if len(found) != 6 {
+		t.Errorf("unexpected problems:\n%s", r)
+	}
+
+	// a kube version without a bundled schema is validated with the nearest
+	// one, the kinds it misses are warnings
+	apps.Path = t.TempDir()
+	if dir, err = chart.ChartsFile(apps); err != nil {
+		t.Fatal(err)
+	}
+	r, err = h.Check(dir, chart.CheckOptions{RenderOptions: chart.RenderOptions{KubeVersion: "v1.25.0"}})
+	if err != nil {
+		t.Fatalf("the generated chart fails for v1.25.0: %v\n%s", err, r)
+	}
+	for _, s := range []string{
+		"no bundled OpenAPI schema of Kubernetes v1.25.0, the manifests are validated with the schema of Kubernetes v1.21.2",
+		"[WARNING] templates/hpa_web.yaml: HorizontalPodAutoscaler/web: autoscaling/v2 HorizontalPodAutoscaler is not in the schema of Kubernetes v1.21.2 used for v1.25.0",
+	} {
+		if r.KubeVersion != "v1.25.0" || !strings.Contains(r.String(), s) {
+			t.Errorf("expected %q, got\n%s", s, r)
+		}
+	}
+}
+
+func TestGenerateWithCheck(t *testing.T) {
+	// grpc probes are not part of Kubernetes v1.21
+	apps, err := chart.LoadSpec(filepath.Join("spec-ports", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := chart.Generate(apps, chart.WithCheck(h, chart.CheckOptions{FailOn: chart.SeverityError}))
+	if !errors.Is(err, chart.ErrCheckFailed) || r.Check.Count(chart.SeverityError) != 1 {
+		t.Fatalf("expected the check to fail, got %v\n%v", err, r.Check)
+	}
+	if entries, _ := ioutil.ReadDir(apps.Path); len(entries) != 0 {
+		t.Fatalf("a failed check left files behind: %v", entries)
+	}
+
+	r, err = chart.Generate(apps, chart.WithCheck(h, chart.CheckOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Check.Count(chart.SeverityError) != 1 || r.Count(chart.FileCreated) == 0 {
+		t.Fatalf("expected the chart to be written with its problems:\n%s%s", r.Check, r)
+	}
+
+	// Kubernetes v1.24 serves grpc probes, its schema is not bundled
+	opts := chart.CheckOptions{RenderOptions: chart.RenderOptions{KubeVersion: "v1.24.0"}, FailOn: chart.SeverityError}
+	if r, err = chart.Generate(apps, chart.WithCheck(h, opts)); err != nil {
+		t.Fatalf("unexpected check failure %v\n%v", err, r.Check)
+	}
+	if !strings.Contains(r.Check.String(), "livenessProbe.grpc: unknown field in the schema of Kubernetes v1.21.2 used for v1.24.0") {
+		t.Fatalf("expected a warning of the grpc probe:\n%s", r.Check)
+	}
+}
+
+func TestLintNamespace(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	dir, err := chart.ChartsFile(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// required and fail only log when linting, the YAML is checked
+	tpl := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: ns\n{{- if not .Release.Namespace }}\n  the namespace is empty\n{{- end }}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "templates", "ns.yaml"), []byte(tpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the namespace defaults to the one of the environment, like every command
+	r, err := h.Lint("", dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range r.Messages {
+		if strings.Contains(msg.Error(), "templates/ns.yaml") {
+			t.Errorf("the namespace is not defaulted: %s", msg)
+		}
+	}
+}
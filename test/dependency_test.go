@@ -0,0 +1,137 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// dependentChart generates the chart of spec-web depending on the chart lib
+// of the repository
+func dependentChart(t *testing.T, repository string) string {
+	t.Helper()
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	if _, err := chart.Generate(apps); err != nil {
+		t.Fatal(err)
+	}
+	cdir := filepath.Join(apps.Path, "web")
+	f, err := os.OpenFile(filepath.Join(cdir, chart.ChartfileName), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("dependencies:\n  - name: lib\n    version: 0.1.0\n    repository: " + repository + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	return cdir
+}
+
+func TestInstallDependencies(t *testing.T) {
+	lib, err := chartutil.Create("lib", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdir := dependentChart(t, "file://"+lib)
+	h, _ := fakeHelm(t)
+	rel, err := h.Install("default", cdir, "web", chart.InstallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rel.Manifest, "# Source: web/charts/lib/templates/deployment.yaml") {
+		t.Fatalf("the dependency is not installed:\n%s", rel.Manifest)
+	}
+	for _, name := range []string{"Chart.lock", "charts/lib-0.1.0.tgz"} {
+		if _, err := os.Stat(filepath.Join(cdir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the dependencies are built from Chart.lock again
+	os.RemoveAll(filepath.Join(cdir, "charts"))
+	if rel, err = h.Upgrade("default", cdir, "web", chart.UpgradeOptions{}); err != nil || rel.Version != 2 {
+		t.Fatalf("unexpected release %+v: %v", rel, err)
+	}
+	os.RemoveAll(filepath.Join(cdir, "charts"))
+	file, err := h.Package(cdir, t.TempDir(), chart.DependencyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loader.Load(file)
+	if err != nil || len(c.Dependencies()) != 1 {
+		t.Fatalf("the dependency is not packaged: %v", err)
+	}
+}
+
+func TestOfflineDependencies(t *testing.T) {
+	repoDir := t.TempDir()
+	lib, err := chartutil.Create("lib", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loader.Load(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chart.PackageChart(c, repoDir); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	defer srv.Close()
+	idx, err := repo.IndexDirectory(repoDir, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	home := t.TempDir()
+	h, err := chart.NewHelm(chart.WithLogger(func(string, ...interface{}) {}), chart.WithEnvFunc(func(settings *cli.EnvSettings) {
+		settings.RepositoryConfig = filepath.Join(home, "repositories.yaml")
+		settings.RepositoryCache = filepath.Join(home, "cache")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AddRepo(&repo.Entry{Name: "local", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	cdir := dependentChart(t, srv.URL)
+
+	// offline with an empty cache
+	if _, err := h.BuildDependencies(cdir, chart.DependencyOptions{Offline: true}); err == nil || !strings.Contains(err.Error(), "not in the cache") {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+	if c, err = h.BuildDependencies(cdir, chart.DependencyOptions{}); err != nil || len(c.Dependencies()) != 1 {
+		t.Fatalf("unexpected dependencies: %v", err)
+	}
+	lock, err := ioutil.ReadFile(filepath.Join(cdir, "Chart.lock"))
+	if err != nil || !strings.Contains(string(lock), "repository: "+srv.URL) {
+		t.Fatalf("unexpected Chart.lock:\n%s %v", lock, err)
+	}
+
+	// the archive comes from the cache without the repository
+	srv.Close()
+	os.RemoveAll(filepath.Join(cdir, "charts"))
+	if c, err = h.BuildDependencies(cdir, chart.DependencyOptions{Offline: true}); err != nil || len(c.Dependencies()) != 1 {
+		t.Fatalf("unexpected offline dependencies: %v", err)
+	}
+	// the update resolves them from the cached index
+	if c, err = h.BuildDependencies(cdir, chart.DependencyOptions{Update: true, Offline: true}); err != nil || len(c.Dependencies()) != 1 {
+		t.Fatalf("unexpected offline update: %v", err)
+	}
+}
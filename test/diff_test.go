@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"helm-maker/chart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	d, err := chart.Diff(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Drift || d.Added != len(d.Files) || d.Changed+d.Removed != 0 {
+		t.Fatalf("expected only added files: %+v", d)
+	}
+	if entries, _ := os.ReadDir(apps.Path); len(entries) != 0 {
+		t.Fatalf("diff wrote files: %v", entries)
+	}
+
+	if _, err := chart.Generate(apps); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = chart.Diff(apps); err != nil || d.Drift || d.String() != "" {
+		t.Fatalf("expected no drift after generate: %+v %v", d, err)
+	}
+
+	// a changed value and a removed kind
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 3
+	apps.Sets[0].Types = []string{"deployment", "svc", "ingress", "serviceaccount", "test"}
+	d, err = chart.Diff(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Added != 0 || d.Changed != 1 || d.Removed != 1 {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+	diff := d.String()
+	for _, s := range []string{"--- a/values.yaml\n+++ b/values.yaml\n", "-    replicaCount: 2\n+    replicaCount: 3\n", "--- a/templates/hpa_web.yaml\n+++ /dev/null\n"} {
+		if !strings.Contains(diff, s) {
+			t.Errorf("diff has no %q:\n%s", s, diff)
+		}
+	}
+	summary, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(summary), `{"path":"templates/hpa_web.yaml","status":"removed"}`) {
+		t.Errorf("unexpected summary %s", summary)
+	}
+
+	// generate does what the diff says
+	r, err := chart.Generate(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count(chart.FileOverwritten) != 1 || r.Count(chart.FileRemoved) != 1 {
+		t.Fatalf("unexpected report:\n%s", r)
+	}
+	if _, err := os.Stat(filepath.Join(r.Dir, "templates", "hpa_web.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("hpa_web.yaml was not removed: %v", err)
+	}
+	if d, err = chart.Diff(apps); err != nil || d.Drift {
+		t.Fatalf("expected no drift after generate: %+v %v", d, err)
+	}
+
+	// files edited by hand and files not generated by helm-maker are kept by
+	// generate, they are drift
+	svc := filepath.Join(r.Dir, "templates", "svc_web.yaml")
+	data, err := os.ReadFile(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(svc, append(data, "# edited\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, "values.yaml"), []byte("replicaCount: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = chart.Diff(apps); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Drift || d.Edited != 2 || d.Added+d.Changed+d.Removed != 0 {
+		t.Fatalf("expected two edited files: %+v", d)
+	}
+	if diff := d.String(); !strings.Contains(diff, "-# edited\n") || !strings.Contains(diff, "-replicaCount: 1\n") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+	if summary, err = json.Marshal(d); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`{"path":"templates/svc_web.yaml","app":"web","kind":"svc","status":"edited","reason":"edited by hand"}`,
+		`{"path":"values.yaml","status":"edited","reason":"not generated by helm-maker"}`,
+	} {
+		if !strings.Contains(string(summary), s) {
+			t.Errorf("summary has no %s: %s", s, summary)
+		}
+	}
+	if d, err = chart.Diff(apps, chart.WithForce()); err != nil || d.Changed != 2 || d.Edited != 0 {
+		t.Fatalf("expected two changed files with force: %+v %v", d, err)
+	}
+}
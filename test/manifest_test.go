@@ -0,0 +1,51 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"strings"
+	"testing"
+)
+
+func TestImportManifests(t *testing.T) {
+	objs, err := chart.LoadManifests("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 4 {
+		t.Fatalf("expected 4 objects, got %d", len(objs))
+	}
+	apps, err := chart.ImportManifests("imported", objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps.Sets) != 2 || apps.Sets[0].Name != "web" || apps.Sets[1].Name != "queue_worker" {
+		t.Fatalf("unexpected apps %+v", apps.Sets)
+	}
+	web := apps.Sets[0]
+	deployment := web.Templates["deployment_web.yaml"]
+	for _, s := range []string{
+		"replicas: {{ .Values.web.value.replicaCount }}",
+		`image: "{{ .Values.web.value.image.repository }}:{{ .Values.web.value.image.tag }}"`,
+		"{{- toYaml .Values.web.value.env | nindent 12 }}",
+		"image: busybox:1.35",
+	} {
+		if !strings.Contains(deployment, s) {
+			t.Errorf("deployment_web.yaml does not contain %q:\n%s", s, deployment)
+		}
+	}
+	if strings.Contains(deployment, "status:") {
+		t.Errorf("status was not removed:\n%s", deployment)
+	}
+	if _, ok := web.Templates["svc_web.yaml"]; !ok {
+		t.Errorf("missing svc_web.yaml in %v", web.Templates)
+	}
+	value := web.Values["value"].(map[string]interface{})
+	if value["replicaCount"] != 3 {
+		t.Errorf("unexpected replicaCount %v", value["replicaCount"])
+	}
+	// images pinned by digest are kept as they are
+	worker := apps.Sets[1].Templates["deployment_queue_worker.yaml"]
+	if !strings.Contains(worker, "@sha256:") {
+		t.Errorf("unexpected worker template:\n%s", worker)
+	}
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"testing"
+)
+
+func TestPipelineAppPerNode(t *testing.T) {
+	p, err := chart.LoadPipeline("pipeline.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := p.Apps(chart.PipelineAppPerNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apps.Name != "appname" || len(apps.Sets) != 3 {
+		t.Fatalf("unexpected apps %s %d", apps.Name, len(apps.Sets))
+	}
+	app := apps.Sets[0]
+	if app.Name != "proxy_20220510_dev" || app.Values["appname"] != "proxy-20220510-dev" {
+		t.Fatalf("unexpected app %s %v", app.Name, app.Values["appname"])
+	}
+	value := app.Values["value"].(map[string]interface{})
+	image := value["image"].(map[string]interface{})
+	if image["repository"] != "registry.tongdun.me/ci/appname" || image["tag"] != "20220510-32cbee7e294" {
+		t.Fatalf("unexpected image %v", image)
+	}
+	limits := value["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+	if limits["cpu"] != "2" || limits["memory"] != "4Gi" {
+		t.Fatalf("unexpected limits %v", limits)
+	}
+	port := value["ports"].([]interface{})[0].(map[string]interface{})
+	if port["containerPort"] != 7077 {
+		t.Fatalf("unexpected port %v", port)
+	}
+	// the test node has not been built, it deploys the image of the other nodes
+	test := apps.Sets[2].Values["value"].(map[string]interface{})["image"].(map[string]interface{})
+	if test["tag"] != "20220510-32cbee7e294" {
+		t.Fatalf("unexpected image of the test node %v", test)
+	}
+}
+
+func TestPipelineValuesPerEnv(t *testing.T) {
+	p, err := chart.LoadPipeline("pipeline.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := p.Apps(chart.PipelineValuesPerEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps.Sets) != 1 || len(apps.Envs) != 3 {
+		t.Fatalf("unexpected apps %d envs %d", len(apps.Sets), len(apps.Envs))
+	}
+	for _, env := range []string{"dev", "smoke", "test"} {
+		if _, ok := apps.Envs[env]["appname"]; !ok {
+			t.Fatalf("missing values of env %s", env)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"errors"
+	"helm-maker/chart"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func reportOf(r *chart.Report, path string) *chart.FileReport {
+	for _, f := range r.Files {
+		if f.Path == path {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestRegenerate(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	cdir := filepath.Join(apps.Path, "web")
+	if _, err := chart.Generate(apps); err != nil {
+		t.Fatal(err)
+	}
+	deploy := filepath.Join(cdir, "templates", "deployment_web.yaml")
+	data, _ := ioutil.ReadFile(deploy)
+	if !strings.HasPrefix(string(data), "{{- /* Generated by helm-maker, sha256:") {
+		t.Fatalf("no header in deployment_web.yaml:\n%s", data)
+	}
+
+	// a second run changes nothing
+	r, err := chart.Generate(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count(chart.FileSkipped) != len(r.Files) {
+		t.Fatalf("expected only skipped files:\n%s", r)
+	}
+	for _, f := range r.Files {
+		if f.Reason != "unchanged" {
+			t.Fatalf("%s was skipped because %q", f.Path, f.Reason)
+		}
+	}
+
+	// edits by hand are kept while the generated content stays the same
+	edited := string(data) + "# keep me\n"
+	if err := ioutil.WriteFile(deploy, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	helpers := filepath.Join(cdir, "templates", "_helpers.tpl")
+	data, _ = ioutil.ReadFile(helpers)
+	user := "{{/* helm-maker:begin user */}}\n"
+	if !strings.Contains(string(data), user) {
+		t.Fatalf("no user region in _helpers.tpl:\n%s", data)
+	}
+	mine := `{{- define "web.mine" -}}mine{{- end }}` + "\n"
+	data = []byte(strings.Replace(string(data), user, user+mine, 1))
+	if err := ioutil.WriteFile(helpers, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 3
+	r, err = chart.Generate(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := reportOf(r, "templates/deployment_web.yaml"); f.Action != chart.FileSkipped || f.Reason != "edited by hand" {
+		t.Fatalf("unexpected report of deployment_web.yaml: %+v", f)
+	}
+	if data, _ := ioutil.ReadFile(deploy); string(data) != edited {
+		t.Fatalf("the edit of deployment_web.yaml is lost:\n%s", data)
+	}
+	if f := reportOf(r, "templates/_helpers.tpl"); f.Action != chart.FileSkipped || f.Reason != "unchanged" {
+		t.Fatalf("unexpected report of _helpers.tpl: %+v", f)
+	}
+	if f := reportOf(r, "values.yaml"); f.Action != chart.FileOverwritten {
+		t.Fatalf("unexpected report of values.yaml: %+v", f)
+	}
+
+	// the user region survives a change of the generated content
+	apps.Sets[1].Types = append(apps.Sets[1].Types, "svc")
+	if _, err := chart.Generate(apps); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(helpers)
+	if !strings.Contains(string(data), user+mine) || !strings.Contains(string(data), `"web.worker.fullname"`) {
+		t.Fatalf("_helpers.tpl was not regenerated with the user region:\n%s", data)
+	}
+
+	// edits of a file whose generated content changed too are a conflict
+	values := filepath.Join(cdir, "values.yaml")
+	data, _ = ioutil.ReadFile(values)
+	edited = string(data) + "extra: true\n"
+	if err := ioutil.WriteFile(values, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	apps.Sets[0].Values["value"].(map[string]interface{})["replicaCount"] = 4
+	r, err = chart.Generate(apps)
+	if !errors.Is(err, chart.ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if f := reportOf(r, "values.yaml"); f.Action != chart.FileConflict {
+		t.Fatalf("unexpected report of values.yaml: %+v", f)
+	}
+	if data, _ := ioutil.ReadFile(values); string(data) != edited {
+		t.Fatalf("the edit of values.yaml is lost:\n%s", data)
+	}
+
+	// force overwrites them
+	if _, err := chart.Generate(apps, chart.WithForce()); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{deploy, values} {
+		if data, _ := ioutil.ReadFile(path); strings.Contains(string(data), "keep me") || strings.Contains(string(data), "extra: true") {
+			t.Fatalf("%s was not overwritten:\n%s", path, data)
+		}
+	}
+}
+
+func TestWriteHelperFileTwice(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "_helpers.tpl")
+	for i := 0; i < 2; i++ {
+		if err := chart.WriteHelperFile(path, `{{- define "<CHARTNAME>.<APPNAME>.x" -}}{{- end }}`+"\n", apps.Sets[0], apps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := ioutil.ReadFile(path)
+	if n := strings.Count(string(data), `define "web.web.x"`); n != 1 {
+		t.Fatalf("the helpers of web are written %d times:\n%s", n, data)
+	}
+}
@@ -0,0 +1,214 @@
+package test
+
+import (
+	"fmt"
+	"helm-maker/chart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// recordingKube is the fake cluster recording how the releases are waited
+// for and updated
+type recordingKube struct {
+	*kubefake.PrintingKubeClient
+	calls []string
+}
+
+func (k *recordingKube) Wait(resources kube.ResourceList, timeout time.Duration) error {
+	k.calls = append(k.calls, fmt.Sprintf("wait %s", timeout))
+	return nil
+}
+
+func (k *recordingKube) WaitWithJobs(resources kube.ResourceList, timeout time.Duration) error {
+	k.calls = append(k.calls, fmt.Sprintf("wait jobs %s", timeout))
+	return nil
+}
+
+func (k *recordingKube) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	k.calls = append(k.calls, fmt.Sprintf("update force=%t", force))
+	return k.PrintingKubeClient.Update(original, target, force)
+}
+
+func (k *recordingKube) flush() string {
+	calls := strings.Join(k.calls, ", ")
+	k.calls = nil
+	return calls
+}
+
+func TestReleaseOptions(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, config := fakeHelm(t)
+	k := &recordingKube{PrintingKubeClient: config.KubeClient.(*kubefake.PrintingKubeClient)}
+	config.KubeClient = k
+
+	// nothing is waited for unless asked
+	rel, err := h.InstallChart("default", c, "web", chart.InstallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := k.flush(); calls != "" || rel.Info.Description != "Install complete" {
+		t.Fatalf("unexpected install %q: %s", rel.Info.Description, calls)
+	}
+	rel, err = h.UpgradeChart("default", c, "web", chart.UpgradeOptions{Wait: true, Description: "scale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := k.flush(); calls != "update force=false, wait "+chart.DefaultTimeout.String() || rel.Info.Description != "scale" {
+		t.Fatalf("unexpected upgrade %q: %s", rel.Info.Description, calls)
+	}
+	if _, err = h.UpgradeChart("default", c, "web", chart.UpgradeOptions{Wait: true, WaitForJobs: true, Timeout: 2 * time.Minute, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := k.flush(); calls != "update force=true, wait jobs 2m0s" {
+		t.Fatalf("unexpected upgrade: %s", calls)
+	}
+	if rel, err = h.UpgradeChart("default", c, "web", chart.UpgradeOptions{DryRun: true}); err != nil || rel.Version != 4 {
+		t.Fatalf("unexpected dry run %+v: %v", rel, err)
+	}
+	if last, err := config.Releases.Last("web"); err != nil || last.Version != 3 || k.flush() != "" {
+		t.Fatalf("the dry run was deployed: %+v %v", last, err)
+	}
+	if err := h.Rollback("default", "web", chart.RollbackOptions{Revision: 1, Wait: true, Timeout: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := config.Releases.Last("web"); err != nil || last.Version != 4 || last.Info.Description != "Rollback to 1" {
+		t.Fatalf("unexpected rollback %+v: %v", last, err)
+	}
+	if calls := k.flush(); calls != "update force=false, wait 1m0s" {
+		t.Fatalf("unexpected rollback: %s", calls)
+	}
+
+	// the CRDs are in the manifest of a dry run unless they are skipped
+	c.Files = append(c.Files, &helmchart.File{Name: "crds/crontab.yaml", Data: []byte("apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: crontabs.stable.example.com\n")})
+	for skip, expected := range map[bool]bool{false: true, true: false} {
+		rel, err := h.InstallChart("default", c, "crd", chart.InstallOptions{DryRun: true, SkipCRDs: skip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(rel.Manifest, "kind: CustomResourceDefinition") != expected {
+			t.Errorf("SkipCRDs %t:\n%s", skip, rel.Manifest)
+		}
+	}
+}
+
+func TestReleaseLabels(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an object without labels
+	c.Templates = append(c.Templates, &helmchart.File{Name: "templates/plain.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: plain\ndata:\n  a: b\n")})
+	h, _ := fakeHelm(t)
+	if _, err := h.InstallChart("default", c, "web", chart.InstallOptions{Labels: map[string]string{"app.kubernetes.io/managed-by": "pipeline"}}); err == nil {
+		t.Fatal("expected the reserved label to be rejected")
+	}
+	labels := map[string]string{"team": "shop", "tier": "web"}
+	rel, err := h.InstallChart("default", c, "web", chart.InstallOptions{Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := 0
+	for _, m := range releaseutil.SplitManifests(rel.Manifest) {
+		var obj struct {
+			Kind     string
+			Metadata struct {
+				Name   string
+				Labels map[string]string
+			}
+		}
+		if err := yaml.Unmarshal([]byte(m), &obj); err != nil {
+			t.Fatal(err)
+		}
+		if obj.Kind == "" {
+			continue
+		}
+		objects++
+		for k, v := range labels {
+			if obj.Metadata.Labels[k] != v {
+				t.Errorf("%s/%s: label %s is %q", obj.Kind, obj.Metadata.Name, k, obj.Metadata.Labels[k])
+			}
+		}
+		if obj.Kind == "Deployment" && obj.Metadata.Labels["app.kubernetes.io/name"] == "" {
+			t.Errorf("%s/%s: the labels of the chart are lost: %v", obj.Kind, obj.Metadata.Name, obj.Metadata.Labels)
+		}
+	}
+	if objects == 0 || !strings.Contains(rel.Manifest, "name: plain") {
+		t.Fatalf("unexpected manifest:\n%s", rel.Manifest)
+	}
+}
+
+func TestReleaseChartVersion(t *testing.T) {
+	repoDir := t.TempDir()
+	for _, version := range []string{"0.1.0", "0.2.0-1.g32cbee7"} {
+		lib, err := chartutil.Create("lib", t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := chartutil.LoadChartfile(filepath.Join(lib, chart.ChartfileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Version = version
+		if err := chartutil.SaveChartfile(filepath.Join(lib, chart.ChartfileName), c); err != nil {
+			t.Fatal(err)
+		}
+		h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.Package(lib, repoDir, chart.DependencyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	defer srv.Close()
+	idx, err := repo.IndexDirectory(repoDir, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteFile(filepath.Join(repoDir, "index.yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	home := t.TempDir()
+	h, _ := fakeHelm(t, chart.WithEnvFunc(func(settings *cli.EnvSettings) {
+		settings.RepositoryConfig = filepath.Join(home, "repositories.yaml")
+		settings.RepositoryCache = filepath.Join(home, "cache")
+	}))
+	if err := h.AddRepo(&repo.Entry{Name: "local", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the latest version, prereleases included, unless a version is set
+	rel, err := h.Install("default", "local/lib", "lib", chart.InstallOptions{})
+	if err != nil || rel.Chart.Metadata.Version != "0.2.0-1.g32cbee7" {
+		t.Fatalf("unexpected release %+v: %v", rel, err)
+	}
+	rel, err = h.Upgrade("default", "local/lib", "lib", chart.UpgradeOptions{Version: "0.1.0"})
+	if err != nil || rel.Chart.Metadata.Version != "0.1.0" {
+		t.Fatalf("unexpected release %+v: %v", rel, err)
+	}
+}
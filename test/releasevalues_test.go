@@ -0,0 +1,86 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestMergeValues(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"client.yaml": "app1:\n  value:\n    replicaCount: 1\n    image: {repository: nginx, tag: \"1.21\"}\n",
+		"dev.yaml":    "app1:\n  value:\n    image: {tag: \"1.22\"}\n    debug: false\n",
+		"app.conf":    "listen 8080\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := chart.NewHelm(chart.WithValueFiles(filepath.Join(dir, "client.yaml")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := h.MergeValues(chart.ValueOptions{
+		ValueFiles:   []string{filepath.Join(dir, "dev.yaml")},
+		JSONValues:   []string{`app1.value.resources={"limits":{"cpu":"1","memory":"1Gi"}}`, `app1.value.debug=true`},
+		Values:       []string{"app1.value.replicaCount=3,app1.value.env[0].name=LOG_LEVEL", "app1.value.debug=null"},
+		StringValues: []string{"app1.version=1.10"},
+		FileValues:   []string{"app1.value.config=" + filepath.Join(dir, "app.conf")},
+		Map: map[string]interface{}{
+			"app1": map[string]interface{}{"value": map[string]interface{}{"image": map[string]interface{}{"tag": "1.23"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+app1:
+  value:
+    replicaCount: 3
+    image: {repository: nginx, tag: "1.23"}
+    debug: null
+    resources: {limits: {cpu: "1", memory: 1Gi}}
+    env: [{name: LOG_LEVEL}]
+    config: "listen 8080\n"
+  version: "1.10"
+`
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte(expected), &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := yaml.Marshal(vals); !reflect.DeepEqual(roundTrip(t, vals), m) {
+		t.Fatalf("unexpected values:\n%s", got)
+	}
+
+	for _, opts := range []chart.ValueOptions{
+		{JSONValues: []string{"app1.value={"}},
+		{JSONValues: []string{"app1.value"}},
+		{Values: []string{"app1.value[=1"}},
+		{FileValues: []string{"app1.value.config=" + filepath.Join(dir, "missing.conf")}},
+	} {
+		if _, err := h.MergeValues(opts); err == nil || !strings.Contains(err.Error(), "failed parsing --set") {
+			t.Errorf("expected an error for %+v, got %v", opts, err)
+		}
+	}
+}
+
+// roundTrip returns the values as they are read from YAML
+func roundTrip(t *testing.T, vals map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := yaml.Marshal(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
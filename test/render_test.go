@@ -0,0 +1,101 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	helmchart "helm.sh/helm/v3/pkg/chart"
+)
+
+func TestRender(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Templates = append(c.Templates, &helmchart.File{
+		Name: "templates/monitor.yaml",
+		Data: []byte(`{{- if .Capabilities.APIVersions.Has "monitoring.coreos.com/v1" }}
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: {{ .Release.Name }}-{{ .Release.Namespace }}
+{{- end }}
+`),
+	})
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := h.RenderChart(c, chart.RenderOptions{
+		ReleaseName: "r",
+		Namespace:   "prod",
+		KubeVersion: "v1.25.0",
+		APIVersions: []string{"monitoring.coreos.com/v1"},
+		Values:      map[string]interface{}{"worker": map[string]interface{}{"value": map[string]interface{}{"replicaCount": 4}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, s := range map[string]string{
+		"web/templates/hpa_web.yaml":           "apiVersion: autoscaling/v2\n",
+		"web/templates/deployment_worker.yaml": "replicas: 4\n",
+		"web/templates/monitor.yaml":           "name: r-prod\n",
+	} {
+		if !strings.Contains(r.Manifests[file], s) {
+			t.Errorf("%s does not contain %q:\n%s", file, s, r.Manifests[file])
+		}
+	}
+	if _, ok := r.Manifests["web/templates/_helpers.tpl"]; ok {
+		t.Errorf("helpers are rendered as a manifest")
+	}
+	if !strings.Contains(r.Notes, "https://web.example.com/") {
+		t.Errorf("unexpected notes:\n%s", r.Notes)
+	}
+
+	// the defaults of helm template, Kubernetes v1.20
+	r, err = h.RenderChart(c, chart.RenderOptions{Namespace: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.Manifests["web/templates/hpa_web.yaml"], "apiVersion: autoscaling/v2beta2\n") {
+		t.Errorf("unexpected hpa:\n%s", r.Manifests["web/templates/hpa_web.yaml"])
+	}
+	if _, ok := r.Manifests["web/templates/monitor.yaml"]; ok {
+		t.Errorf("monitor is rendered without its API version")
+	}
+	if !strings.Contains(r.String(), "---\n# Source: web/templates/svc_web.yaml\n") {
+		t.Errorf("unexpected manifests:\n%s", r)
+	}
+
+	// templates which do not render to YAML are errors
+	c.Templates = append(c.Templates, &helmchart.File{Name: "templates/broken.yaml", Data: []byte("labels:\n  app: {{ .Values.web.appname }}\n {{ .Values.web.appname }}\n")})
+	if _, err := h.RenderChart(c, chart.RenderOptions{}); err == nil || !strings.Contains(err.Error(), "web/templates/broken.yaml") {
+		t.Fatalf("expected a YAML parse error of broken.yaml, got %v", err)
+	}
+}
+
+func TestRenderInitApps(t *testing.T) {
+	r, err := renderApps(t, chart.InitApps(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployments := 0
+	for file, m := range r.Manifests {
+		if !strings.Contains(m, "kind: Deployment\n") {
+			continue
+		}
+		deployments++
+		if !strings.Contains(m, `image: "nginx:1.16.0"`) {
+			t.Errorf("unexpected image of %s:\n%s", file, m)
+		}
+	}
+	if deployments == 0 {
+		t.Fatalf("no deployment rendered:\n%s", r)
+	}
+}
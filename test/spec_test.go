@@ -0,0 +1,63 @@
+package test
+
+import (
+	"errors"
+	"helm-maker/chart"
+	"testing"
+)
+
+func TestLoadSpecDir(t *testing.T) {
+	apps, err := chart.LoadSpec("spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apps.Name != "demo" || apps.Version != "1.0.0" {
+		t.Fatalf("unexpected chart %s %s", apps.Name, apps.Version)
+	}
+	if len(apps.Sets) != 2 || apps.Sets[0].Name != "web" || apps.Sets[1].Name != "worker" {
+		t.Fatalf("unexpected apps %+v", apps.Sets)
+	}
+	value := apps.Sets[1].Values["value"].(map[string]interface{})
+	if value["replicaCount"] != 1 {
+		t.Fatalf("unexpected replicaCount %v", value["replicaCount"])
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	spec := `name: demo
+apps:
+  - name: web
+    types: [deployment, nope]
+  - name: web-2
+    types: deployment
+`
+	_, err := chart.ParseSpec("apps.yaml", []byte(spec))
+	var errs chart.SpecErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected SpecErrors, got %v", err)
+	}
+	expected := []struct {
+		line  int
+		field string
+	}{
+		{4, "apps[0].types[1]"},
+		{6, "apps[1].types"},
+		{5, "apps[1].name"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), err)
+	}
+	for i, e := range expected {
+		if errs[i].Line != e.line || errs[i].Field != e.field {
+			t.Errorf("error %d: expected line %d field %s, got %s", i, e.line, e.field, errs[i])
+		}
+	}
+}
+
+func TestParseSpecSyntaxError(t *testing.T) {
+	_, err := chart.ParseSpec("apps.json", []byte("{\n  \"name\": \"demo\",\n  \"apps\": [\n}"))
+	var errs chart.SpecErrors
+	if !errors.As(err, &errs) || errs[0].Line == 0 {
+		t.Fatalf("expected a syntax error with a line, got %v", err)
+	}
+}
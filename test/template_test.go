@@ -0,0 +1,308 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+// renderSpec generates the chart of a spec into a temp dir and renders it
+func renderSpec(t *testing.T, spec string) string {
+	t.Helper()
+	apps, err := chart.LoadSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	dir, err := chart.ChartsFile(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := h.Template("default", dir, "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+// renderApps builds the chart of the apps in memory and renders it with the values
+func renderApps(t *testing.T, apps *chart.Apps, values map[string]interface{}) (*chart.Rendered, error) {
+	t.Helper()
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.RenderChart(c, chart.RenderOptions{Namespace: "default", Values: values})
+}
+
+// renderValues renders the chart of a spec with the values, the manifests are
+// by template file
+func renderValues(t *testing.T, spec string, values map[string]interface{}) map[string]string {
+	t.Helper()
+	apps, err := chart.LoadSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := renderApps(t, apps, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Manifests
+}
+
+func TestStorageKinds(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-storage", "apps.yaml"))
+	for _, s := range []string{
+		"kind: PersistentVolume\n",
+		"volumeName: files-pv",
+		"claimName: files-data",
+		"mountPath: /srv",
+		"serviceName: db-headless",
+		"clusterIP: None",
+		"volumeClaimTemplates:",
+		"mountPath: /var/lib/postgresql/data",
+		"storage: 5Gi",
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q", s)
+		}
+	}
+	// the claim of the StatefulSet comes from its volumeClaimTemplates
+	if strings.Contains(manifest, "claimName: db-data") {
+		t.Errorf("StatefulSet pod references a claim:\n%s", manifest)
+	}
+}
+
+func TestJobKinds(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-jobs", "apps.yaml"))
+	for _, s := range []string{
+		"kind: Job\n",
+		`"helm.sh/hook": "pre-install,pre-upgrade"`,
+		"backoffLimit: 2",
+		"restartPolicy: OnFailure",
+		"kind: CronJob\n",
+		`schedule: "0 2 * * *"`,
+		"concurrencyPolicy: Replace",
+		"successfulJobsHistoryLimit: 0",
+		"restartPolicy: Never",
+		"kind: DaemonSet\n",
+		"- operator: Exists",
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q", s)
+		}
+	}
+	// job pods are not probed
+	job := manifest[strings.Index(manifest, "kind: Job\n"):]
+	if strings.Contains(job, "livenessProbe") {
+		t.Errorf("job has probes:\n%s", job)
+	}
+}
+
+// the zero values of the job spec are rendered, not dropped
+func TestJobZeroValues(t *testing.T) {
+	job := map[string]interface{}{"backoffLimit": 0, "ttlSecondsAfterFinished": 0, "completions": 0, "parallelism": 0}
+	manifests := renderValues(t, filepath.Join("spec-jobs", "apps.yaml"),
+		map[string]interface{}{"migrate": map[string]interface{}{"value": map[string]interface{}{"job": job}}})
+	manifest := manifests["batch/templates/job_migrate.yaml"]
+	for _, s := range []string{"backoffLimit: 0\n", "ttlSecondsAfterFinished: 0\n", "completions: 0\n", "parallelism: 0\n"} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("job does not contain %q:\n%s", s, manifest)
+		}
+	}
+	if strings.Contains(manifest, "activeDeadlineSeconds") {
+		t.Errorf("job has an unset activeDeadlineSeconds:\n%s", manifest)
+	}
+}
+
+func TestConfigKinds(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-config", "apps.yaml"))
+	for _, s := range []string{
+		"kind: ConfigMap\n",
+		"name: web-config",
+		`LOG_LEVEL: "info"`,
+		`app.properties: "server.port=8080\nserver.name=web\n"`,
+		"kind: Secret\n",
+		`password: "czNjcmV0"`,
+		"checksum/config: ",
+		"checksum/secret: ",
+		"mountPath: /etc/web\n",
+		"secretName: web-secret",
+		"- secretRef:",
+		// api mounts a ConfigMap which is not part of the chart
+		"name: shared-config",
+		"mountPath: /conf",
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q", s)
+		}
+	}
+	if strings.Count(manifest, "kind: ConfigMap\n") != 1 {
+		t.Errorf("expected a single ConfigMap:\n%s", manifest)
+	}
+}
+
+func TestWebKinds(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-web", "apps.yaml"))
+	for _, s := range []string{
+		"kind: Ingress\n",
+		"ingressClassName: nginx",
+		`- host: "web.example.com"`,
+		"secretName: web-tls",
+		"number: 8080",
+		"kind: HorizontalPodAutoscaler\n",
+		"maxReplicas: 5",
+		"averageUtilization: 80",
+		"kind: ServiceAccount\n",
+		"serviceAccountName: web",
+		`"helm.sh/hook": test`,
+		"args: ['web:8080']",
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q", s)
+		}
+	}
+	// the HorizontalPodAutoscaler owns the replicas of web
+	if strings.Contains(manifest, "replicas: 2") {
+		t.Errorf("web sets its replicas:\n%s", manifest)
+	}
+}
+
+func TestNotes(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps.Path = t.TempDir()
+	dir, err := chart.ChartsFile(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chrt, err := loader.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := chartutil.ToRenderValues(chrt, nil, chartutil.ReleaseOptions{Name: "r", Namespace: "default"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := engine.Render(chrt, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := files["web/templates/NOTES.txt"]
+	for _, s := range []string{
+		"web:\n  https://web.example.com/",
+		"worker:\n  kubectl get pods --namespace default",
+	} {
+		if !strings.Contains(notes, s) {
+			t.Errorf("notes do not contain %q:\n%s", s, notes)
+		}
+	}
+}
+
+func TestSelectorLabels(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-web", "apps.yaml"))
+	docs := strings.Split(manifest, "\n---\n")
+	var service, web, worker string
+	for _, doc := range docs {
+		switch {
+		case strings.Contains(doc, "kind: Service\n"):
+			service = doc
+		case strings.Contains(doc, "# Source: web/templates/deployment_web.yaml"):
+			web = doc
+		case strings.Contains(doc, "# Source: web/templates/deployment_worker.yaml"):
+			worker = doc
+		}
+	}
+	selector := service[strings.Index(service, "selector:"):]
+	if !strings.Contains(selector, "app.kubernetes.io/component: web") {
+		t.Errorf("service selector does not select the web component:\n%s", service)
+	}
+	if !strings.Contains(web, "app.kubernetes.io/component: web\n") {
+		t.Errorf("web pods are not labeled with their component:\n%s", web)
+	}
+	if strings.Contains(worker, "app.kubernetes.io/component: web\n") || !strings.Contains(worker, "app.kubernetes.io/component: worker\n") {
+		t.Errorf("worker pods are not labeled with their component:\n%s", worker)
+	}
+}
+
+func TestPortsAndProbes(t *testing.T) {
+	manifest := renderSpec(t, filepath.Join("spec-ports", "apps.yaml"))
+	for _, s := range []string{
+		// container ports
+		"- name: grpc\n              containerPort: 9090",
+		"- name: metrics\n              containerPort: 9100",
+		"- name: tcp-7077\n              containerPort: 7077",
+		// service ports
+		"- port: 9090\n      targetPort: grpc",
+		"appProtocol: grpc\n      nodePort: 30090",
+		"- port: 80\n      targetPort: tcp-7077",
+		// probes
+		"livenessProbe:\n            grpc:\n              port: 9090\n            initialDelaySeconds: 30",
+		"readinessProbe:\n            tcpSocket:\n              port: grpc\n            periodSeconds: 5",
+		"startupProbe:\n            exec:",
+		"failureThreshold: 30",
+	} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q", s)
+		}
+	}
+	if strings.Contains(manifest, "targetPort: metrics") {
+		t.Errorf("service exposes the metrics port:\n%s", manifest)
+	}
+	// worker has no ports, so it has no default probes
+	worker := manifest[strings.Index(manifest, "# Source: ports/templates/deployment_worker.yaml"):]
+	if strings.Contains(worker, "Probe") || strings.Contains(worker, "ports:") {
+		t.Errorf("worker has ports or probes:\n%s", worker)
+	}
+}
+
+// a Service without ports is rejected, none is rendered without an exposed port
+func TestServiceWithoutPorts(t *testing.T) {
+	ports := []interface{}{map[string]interface{}{"name": "metrics", "containerPort": 9100, "expose": false}}
+	manifests := renderValues(t, filepath.Join("spec-ports", "apps.yaml"),
+		map[string]interface{}{"api": map[string]interface{}{"value": map[string]interface{}{"ports": ports}}})
+	if m, ok := manifests["ports/templates/svc_api.yaml"]; ok {
+		t.Errorf("a Service without ports is rendered:\n%s", m)
+	}
+	if !strings.Contains(manifests["ports/templates/deployment_api.yaml"], "containerPort: 9100") {
+		t.Errorf("unexpected deployment:\n%s", manifests["ports/templates/deployment_api.yaml"])
+	}
+}
+
+// an app without the configmap or secret kinds only mounts existing ones
+func TestConfigWithoutKinds(t *testing.T) {
+	spec := filepath.Join("spec-config", "apps.yaml")
+	value := map[string]interface{}{
+		"configMap": map[string]interface{}{"name": nil, "envFrom": true, "data": map[string]interface{}{"a": "b"}},
+		"secret":    map[string]interface{}{"mountPath": "/secret", "envFrom": true},
+	}
+	api := renderValues(t, spec, map[string]interface{}{"api": map[string]interface{}{"value": value}})["config/templates/deployment_api.yaml"]
+	for _, s := range []string{"api-config", "api-secret", "envFrom", "mountPath", "checksum/"} {
+		if strings.Contains(api, s) {
+			t.Errorf("api refers to %q of an object which is not rendered:\n%s", s, api)
+		}
+	}
+
+	value = map[string]interface{}{"secret": map[string]interface{}{"name": "shared-secret", "mountPath": "/secret"}}
+	api = renderValues(t, spec, map[string]interface{}{"api": map[string]interface{}{"value": value}})["config/templates/deployment_api.yaml"]
+	for _, s := range []string{"secretName: shared-secret", "mountPath: /secret", "name: shared-config", "mountPath: /conf"} {
+		if !strings.Contains(api, s) {
+			t.Errorf("api does not contain %q:\n%s", s, api)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"helm-maker/chart"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func valuesFile(t *testing.T, apps *chart.Apps) string {
+	t.Helper()
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range c.Raw {
+		if f.Name == chart.ValuesfileName {
+			return string(f.Data)
+		}
+	}
+	t.Fatal("no values.yaml")
+	return ""
+}
+
+// inOrder tells whether the lines are found in s one after the other
+func inOrder(s string, lines ...string) bool {
+	for _, l := range lines {
+		i := strings.Index(s, l+"\n")
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(l):]
+	}
+	return true
+}
+
+func TestValuesOrder(t *testing.T) {
+	spec := `
+name: ordered
+apps:
+  - name: web
+    types: [deployment, svc]
+    values:
+      value:
+        service: {type: ClusterIP, port: 8080}
+        # pods of web
+        replicaCount: 2
+        image: {tag: "1.21", repository: nginx}
+        resources: {}
+        zzz: true
+        labels: {team: web}
+        env:
+          - {name: LOG_LEVEL, value: info}
+      appname: web
+    schema:
+      value.labels: {description: labels of web}
+  - name: api
+    types: [deployment]
+    values:
+      appname: api
+      value:
+        image: {repository: api}
+`
+	apps, err := chart.ParseSpec("apps.yaml", []byte(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := valuesFile(t, apps)
+	if !inOrder(values,
+		"# web: deployment, svc",
+		"web:",
+		"  value:",
+		"    service:",
+		"      type: ClusterIP",
+		"    # pods of web",
+		"    replicaCount: 2",
+		"    # Image of the container.",
+		"    image:",
+		"      # Overrides the image tag whose default is the chart appVersion.",
+		`      tag: "1.21"`,
+		"      repository: nginx",
+		"    resources: {}",
+		"    #   cpu: 100m",
+		"    zzz: true",
+		"    # labels of web",
+		"    labels:",
+		"    env:",
+		"      - name: LOG_LEVEL",
+		"  # Name of the app, the name of its objects unless value.fullnameOverride is set.",
+		"  appname: web",
+		"# api: deployment",
+		"api:",
+		"  appname: api",
+	) {
+		t.Fatalf("unexpected values.yaml:\n%s", values)
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte(values), &m); err != nil {
+		t.Fatal(err)
+	}
+	if web := m["web"].(map[string]interface{}); web["value"].(map[string]interface{})["replicaCount"] != float64(2) {
+		t.Fatalf("unexpected values %v", m)
+	}
+	if again := valuesFile(t, apps); again != values {
+		t.Fatalf("values.yaml is not stable:\n%s\n%s", values, again)
+	}
+}
+
+func TestBaseValues(t *testing.T) {
+	dir := t.TempDir()
+	base := `# Values of the shop chart.
+
+# shared by every app
+global:
+  domain: example.com
+
+# the web app, tuned by hand
+web:
+  value:
+    # keep two pods at least
+    replicaCount: 3
+    podAnnotations:
+      team: shop
+`
+	spec := `
+name: shop
+baseValues: base.yaml
+apps:
+  - name: web
+    types: [deployment]
+    values:
+      appname: web
+      value:
+        replicaCount: 2
+        image: {repository: nginx}
+  - name: api
+    types: [deployment]
+    values:
+      appname: api
+`
+	for name, data := range map[string]string{"base.yaml": base, "apps.yaml": spec} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apps, err := chart.LoadSpec(filepath.Join(dir, "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := valuesFile(t, apps)
+	if !inOrder(values,
+		"# Values of the shop chart.",
+		"# shared by every app",
+		"global:",
+		"  domain: example.com",
+		"# the web app, tuned by hand",
+		"web:",
+		"  value:",
+		"    # keep two pods at least",
+		"    replicaCount: 2",
+		"    podAnnotations:",
+		"      team: shop",
+		"    image:",
+		"      repository: nginx",
+		"  appname: web",
+		"# api: deployment",
+		"api:",
+	) {
+		t.Fatalf("unexpected values.yaml:\n%s", values)
+	}
+
+	apps.Path = t.TempDir()
+	if err := chart.WriteValueFile(apps.Path, apps, []byte("replicaCount: [")); err == nil {
+		t.Fatal("expected an error for an invalid base")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.yaml"), []byte("- web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = chart.LoadSpec(filepath.Join(dir, "apps.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "apps.yaml:3:13: baseValues:") || !strings.Contains(err.Error(), "must be a mapping") {
+		t.Fatalf("expected a baseValues error, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"encoding/json"
+	"helm-maker/chart"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValuesSchema(t *testing.T) {
+	apps, err := chart.LoadSpec(filepath.Join("spec-web", "apps.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Required   []string
+		Properties map[string]struct {
+			Required   []string
+			Properties map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(c.Schema, &schema); err != nil {
+		t.Fatalf("invalid values.schema.json: %v\n%s", err, c.Schema)
+	}
+	if strings.Join(schema.Required, ",") != "web,worker" || strings.Join(schema.Properties["web"].Required, ",") != "appname,value" {
+		t.Fatalf("unexpected required keys:\n%s", c.Schema)
+	}
+
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := func(value map[string]interface{}) chart.RenderOptions {
+		return chart.RenderOptions{Values: map[string]interface{}{"web": map[string]interface{}{"value": value}}}
+	}
+	// overrides of the right type and in range
+	for _, value := range []map[string]interface{}{
+		{"replicaCount": 3, "image": map[string]interface{}{"tag": 2}},
+		{"service": map[string]interface{}{"type": "NodePort", "nodePort": 30080}, "extra": true},
+	} {
+		if _, err := h.RenderChart(c, web(value)); err != nil {
+			t.Errorf("%v is rejected: %v", value, err)
+		}
+	}
+	// bad overrides
+	for _, value := range []map[string]interface{}{
+		{"replicaCount": -1},
+		{"replicaCount": "3"},
+		{"service": map[string]interface{}{"type": "Nodeport"}},
+		{"service": map[string]interface{}{"port": 70000}},
+		{"image": map[string]interface{}{"pullPolicy": "always"}},
+		{"autoscaling": map[string]interface{}{"targetCPUUtilizationPercentage": 120}},
+	} {
+		if _, err := h.RenderChart(c, web(value)); err == nil || !strings.Contains(err.Error(), "values don't meet the specifications of the schema") {
+			t.Errorf("%v is not rejected: %v", value, err)
+		}
+	}
+}
+
+func TestValuesSchemaAnnotations(t *testing.T) {
+	spec := `
+name: annotated
+apps:
+  - name: web
+    types: [deployment]
+    values:
+      appname: web
+      value:
+        image: {repository: nginx, tag: v1.0.0}
+        ports: [{name: http, containerPort: 80}]
+        env: {LOG_LEVEL: info}
+    schema:
+      value.image.tag: {type: string, pattern: "^v"}
+      value.ports[].name: {required: true}
+      value.env.LOG_LEVEL: {enum: [debug, info], description: log level of web}
+`
+	apps, err := chart.ParseSpec("apps.yaml", []byte(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := chart.BuildChart(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := chart.NewHelm(chart.WithLogger(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RenderChart(c, chart.RenderOptions{}); err != nil {
+		t.Fatalf("the values of the spec are rejected: %v\n%s", err, c.Schema)
+	}
+	for _, value := range []map[string]interface{}{
+		{"image": map[string]interface{}{"tag": "1.0.0"}},
+		{"ports": []interface{}{map[string]interface{}{"containerPort": 80}}},
+		{"env": map[string]interface{}{"LOG_LEVEL": "trace"}},
+	} {
+		opts := chart.RenderOptions{Values: map[string]interface{}{"web": map[string]interface{}{"value": value}}}
+		if _, err := h.RenderChart(c, opts); err == nil {
+			t.Errorf("%v is not rejected", value)
+		}
+	}
+
+	apps.Sets[0].Schema = map[string]map[string]interface{}{"value.image[]": {"type": "string"}}
+	if _, err := chart.BuildChart(apps); err == nil || !strings.Contains(err.Error(), "value.image is not a list") {
+		t.Fatalf("expected an annotation error, got %v", err)
+	}
+}
+
+// the placeholders of the default apps, like configMap: "", can be overridden
+// with the object form
+func TestValuesSchemaPlaceholders(t *testing.T) {
+	value := map[string]interface{}{
+		"configMap":        map[string]interface{}{"data": map[string]interface{}{"a": "b"}},
+		"secret":           map[string]interface{}{"stringData": map[string]interface{}{"password": "s3cret"}},
+		"imagePullSecrets": []interface{}{map[string]interface{}{"name": "registry"}},
+		"resources":        map[string]interface{}{"limits": map[string]interface{}{"cpu": "1"}},
+	}
+	if _, err := renderApps(t, chart.InitApps(), map[string]interface{}{"app1": map[string]interface{}{"value": value}}); err != nil {
+		t.Fatalf("%v is rejected: %v", value, err)
+	}
+	value = map[string]interface{}{"replicaCount": "2"}
+	if _, err := renderApps(t, chart.InitApps(), map[string]interface{}{"app1": map[string]interface{}{"value": value}}); err == nil {
+		t.Fatal("a string replicaCount is not rejected")
+	}
+}